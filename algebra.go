@@ -0,0 +1,276 @@
+package mast
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Value is whatever an Algebra computes with, such as a Matrix for the
+// default Float64 algebra.
+type Value interface{}
+
+// An Algebra gives meaning to the values, literals and operators of an
+// expression. The evaluator handles variables, function calls and multiple
+// assignment, and delegates everything else to the Algebra of its Env.
+//
+// Operators are identified by their glyph, as in Binary("+", x, y) or
+// Unary("'", x). Applying a value that is not a function, as in "A x", is
+// treated as Binary("*", A, x). An Algebra that does not support an operator
+// should return an *UnknownOperatorError; any error without an Expr is
+// attributed to the expression being evaluated.
+type Algebra interface {
+	// Converts an argument passed to Eval or Run, such as a *float64, into a
+	// Value. Unsupported types should give an *UnsupportedTypeError.
+	Read(arg interface{}) (Value, error)
+
+	// Stores v into an argument passed to Eval or Run.
+	Write(arg interface{}, v Value) error
+
+	// Returns the value of a numeric literal.
+	Literal(n *Num) (Value, error)
+
+	// Computes a prefix or suffix operator, such as "-" or "'".
+	Unary(op string, x Value) (Value, error)
+
+	// Computes an infix operator, such as "+" or "*".
+	Binary(op string, x, y Value) (Value, error)
+}
+
+// A Library is an Algebra that also provides built-in functions, which are
+// available in every Env created with NewAlgebraEnv.
+type Library interface {
+	Algebra
+
+	// Returns the built-in functions, keyed by name.
+	Funcs() map[string]ValueFunc
+
+	// Returns the built-in functions returning several values, keyed by name.
+	MultiFuncs() map[string]MultiValueFunc
+}
+
+// A Constants is an Algebra with named constants, such as the imaginary unit
+// of Complex128. A constant is used wherever its name is read before being
+// assigned, and so is never one of the variables of a Program.
+type Constants interface {
+	Algebra
+
+	// Returns the value of the named constant, if there is one.
+	Constant(name string) (Value, bool)
+}
+
+// A Selector is an Algebra that can evaluate conditionals, as in
+// "x > 0 ? x : 0". Both branches are evaluated, so that the test may be a
+// mask choosing between them element by element, unless the Selector is also
+// a Chooser.
+type Selector interface {
+	Algebra
+
+	// Returns x where mask is true, and y elsewhere.
+	Select(mask, x, y Value) (Value, error)
+}
+
+// A Chooser is a Selector whose tests are always scalars, so that a
+// conditional evaluates only the branch it chooses, and a guard such as
+// "x != 0 ? 1 / x : 0" works.
+type Chooser interface {
+	Selector
+
+	// Reports whether test chooses the first branch.
+	Choose(test Value) (bool, error)
+}
+
+// A ValueFunc is like a Func, but works with the values of any Algebra.
+type ValueFunc func(args ...Value) (Value, error)
+
+// A MultiValueFunc is like a MultiFunc, but works with the values of any
+// Algebra.
+type MultiValueFunc func(args ...Value) ([]Value, error)
+
+// Float64 is the default Algebra, which evaluates using Matrix values. It
+// accepts *float64, *[]float64, *[][]float64 and *Matrix arguments. The
+// comparison and logical operators give masks of 1 where they hold and 0
+// elsewhere, and treat any nonzero element as true.
+type Float64 struct{}
+
+// Converts arg into a Matrix.
+func (Float64) Read(arg interface{}) (Value, error) {
+	return readMat(arg)
+}
+
+// Stores the Matrix v into arg.
+func (Float64) Write(arg interface{}, v Value) error {
+	m, ok := v.(Matrix)
+	if !ok {
+		return &UnsupportedTypeError{Value: v}
+	}
+	return writeMat(arg, m)
+}
+
+// Returns n as a 1-by-1 Matrix.
+func (Float64) Literal(n *Num) (Value, error) {
+	if math.IsInf(n.Value, 0) {
+		return nil, errTooLarge(n)
+	}
+	return scalarMat(n.Value), nil
+}
+
+// Reports that n is too large for a float64, as "1e400" is.
+func errTooLarge(n *Num) error {
+	return &ArithmeticError{Reason: fmt.Sprintf("cannot represent %s as a float64", n.Text)}
+}
+
+// Computes "'" and ".'" (both transpose), "-" (negation), "+" (identity)
+// and "!" (logical not).
+func (Float64) Unary(op string, x Value) (Value, error) {
+	a, ok := x.(Matrix)
+	if !ok {
+		return nil, &UnsupportedTypeError{Value: x}
+	}
+
+	switch op {
+	case "'", ".'":
+		return a.T(), nil
+	case "-":
+		return scaleMat(-1, a), nil
+	case "+":
+		return a, nil
+	case "!":
+		return notMat(a), nil
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+}
+
+// Computes the arithmetic operators on matrices.
+func (Float64) Binary(op string, x, y Value) (Value, error) {
+	a, ok := x.(Matrix)
+	if !ok {
+		return nil, &UnsupportedTypeError{Value: x}
+	}
+	b, ok := y.(Matrix)
+	if !ok {
+		return nil, &UnsupportedTypeError{Value: y}
+	}
+
+	var result Matrix
+	var err error
+	switch op {
+	case "+":
+		result, err = addMats(a, b)
+	case "-":
+		result, err = subMats(a, b)
+	case "*":
+		result, err = multMats(a, b)
+	case "/":
+		result, err = divMats(a, b)
+	case "\\":
+		result, err = solveMats(a, b)
+	case "^":
+		result, err = powMats(a, b)
+	case ".*":
+		result, err = timesMats(a, b)
+	case "./":
+		result, err = quoMats(a, b)
+	case ".^":
+		result, err = raiseMats(a, b)
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		result, err = compareMats(op, a, b)
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Chooses each element from x where mask is nonzero, and from y elsewhere.
+func (Float64) Select(mask, x, y Value) (Value, error) {
+	mats, err := matrices([]Value{mask, x, y})
+	if err != nil {
+		return nil, err
+	}
+	result, err := selectMats(mats[0], mats[1], mats[2])
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Returns the built-in functions, such as inv and sum.
+func (Float64) Funcs() map[string]ValueFunc {
+	funcs := map[string]ValueFunc{}
+	for name, fn := range builtins {
+		funcs[name] = fn.value()
+	}
+	return funcs
+}
+
+// Returns the built-in functions returning several values, such as qr.
+func (Float64) MultiFuncs() map[string]MultiValueFunc {
+	multis := map[string]MultiValueFunc{}
+	for name, fn := range multiBuiltins {
+		multis[name] = fn.value()
+	}
+	return multis
+}
+
+// Converts each argument to a Matrix, for calling a Func or MultiFunc.
+func matrices(args []Value) ([]Matrix, error) {
+	result := []Matrix{}
+	for _, arg := range args {
+		m, ok := arg.(Matrix)
+		if !ok {
+			return nil, &UnsupportedTypeError{Value: arg}
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// Adapts this Func to accept and return Values.
+func (fn Func) value() ValueFunc {
+	return func(args ...Value) (Value, error) {
+		mats, err := matrices(args)
+		if err != nil {
+			return nil, err
+		}
+		result, err := fn(mats...)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
+
+// Adapts this MultiFunc to accept and return Values.
+func (fn MultiFunc) value() MultiValueFunc {
+	return func(args ...Value) ([]Value, error) {
+		mats, err := matrices(args)
+		if err != nil {
+			return nil, err
+		}
+		results, err := fn(mats...)
+		if err != nil {
+			return nil, err
+		}
+
+		values := []Value{}
+		for _, result := range results {
+			values = append(values, result)
+		}
+		return values, nil
+	}
+}
+
+// Returns the where function of the given Selector, so that where(mask, x,
+// y) is the same as "mask ? x : y".
+func whereValue(sel Selector) ValueFunc {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 3 {
+			return nil, &ArgumentError{Reason: fmt.Sprintf(
+				"expecting 3 arguments, got %d", len(args))}
+		}
+		return sel.Select(args[0], args[1], args[2])
+	}
+}
@@ -0,0 +1,102 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"strings"
+	"testing"
+)
+
+// An Algebra over strings, which writes out each computation it performs.
+type formula struct{}
+
+func (formula) Read(arg interface{}) (Value, error) {
+	if s, ok := arg.(*string); ok {
+		return *s, nil
+	}
+	return nil, &UnsupportedTypeError{Value: arg}
+}
+
+func (formula) Write(arg interface{}, v Value) error {
+	if s, ok := arg.(*string); ok {
+		*s = v.(string)
+		return nil
+	}
+	return &UnsupportedTypeError{Value: arg}
+}
+
+func (formula) Literal(n *Num) (Value, error) {
+	return n.Text, nil
+}
+
+func (formula) Unary(op string, x Value) (Value, error) {
+	if op == "'" {
+		return x.(string) + op, nil
+	}
+	return op + x.(string), nil
+}
+
+func (formula) Binary(op string, x, y Value) (Value, error) {
+	if op == "^" {
+		return nil, &UnknownOperatorError{Op: op}
+	}
+	return "(" + x.(string) + op + y.(string) + ")", nil
+}
+
+func TestAlgebra(t *testing.T) {
+	env := NewAlgebraEnv(formula{})
+	env.RegisterValueFunc("upper", func(args ...Value) (Value, error) {
+		return strings.ToUpper(args[0].(string)), nil
+	})
+	env.RegisterMultiValueFunc("split", func(args ...Value) ([]Value, error) {
+		return []Value{args[0].(string)[:1], args[0].(string)[1:]}, nil
+	})
+
+	y, a, b := "", "a", "b"
+	if err := env.Eval("y = -a * 2 + upper(b')", &y, &a, &b); err != nil {
+		t.Fatal(err)
+	}
+	if y != "((-a*2)+B')" {
+		t.Errorf("got y = %s", y)
+	}
+
+	if err := env.Eval("y = a b", &y, &a, &b); err != nil {
+		t.Fatal(err)
+	} else if y != "(a*b)" {
+		t.Errorf("got y = %s, expecting application to multiply", y)
+	}
+
+	p, q, ab := "", "", "ab"
+	if err := env.Eval("p, q = split(x)", &p, &q, &ab); err != nil {
+		t.Fatal(err)
+	} else if p != "a" || q != "b" {
+		t.Errorf("got p = %s, q = %s", p, q)
+	}
+
+	// errors from the Algebra are attributed to the failing expression
+	err := env.Eval("y = a + b^2", &y, &a, &b)
+	if err == nil || err.Error() != "unknown operator \"^\", in (b ^ 2) at 1:9" {
+		t.Errorf("got %v", err)
+	}
+
+	// the Float64 built-ins are not available, and Matrix functions fail
+	inv := "inv"
+	if err := env.Eval("y = inv(a)", &y, &inv, &a); err != nil || y != "(inv*a)" {
+		t.Errorf("got %v, y = %s; expecting inv to be a variable", err, y)
+	}
+	env.RegisterFunc("sq", func(args ...Matrix) (Matrix, error) {
+		return args[0], nil
+	})
+	if _, ok := env.Eval("y = sq(a)", &y, &a).(*UnsupportedTypeError); !ok {
+		t.Errorf("expected an *UnsupportedTypeError from a Matrix function")
+	}
+}
+
+func TestFloat64(t *testing.T) {
+	env := NewAlgebraEnv(Float64{})
+	y, x := 0.0, 4.0
+	if err := env.Eval("y = sqrt(x) .^ 3 - 1", &y, &x); err != nil {
+		t.Fatal(err)
+	} else if y != 7 {
+		t.Errorf("got y = %v, expecting 7", y)
+	}
+}
@@ -0,0 +1,435 @@
+package mast
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// BigRat is an Algebra over exact rational matrices, whose values are
+// [][]*big.Rat. It accepts *big.Rat, *[]*big.Rat and *[][]*big.Rat
+// arguments. Literals are read exactly, so "0.1" is one tenth, and division
+// by zero gives an *ArithmeticError, as does a result with more than a
+// million or so bits.
+type BigRat struct{}
+
+// BigFloat is an Algebra over arbitrary-precision matrices, whose values are
+// [][]*big.Float. It accepts *big.Float, *[]*big.Float and *[][]*big.Float
+// arguments.
+type BigFloat struct {
+	// The number of mantissa bits in each result; if zero, 256 is used.
+	Prec uint
+}
+
+type ratField struct{}
+
+type floatField struct {
+	prec uint
+}
+
+// Returns a copy of r, treating nil as zero.
+func copyRat(r *big.Rat) *big.Rat {
+	if r == nil {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Set(r)
+}
+
+// Converts arg into a [][]*big.Rat, copying each element.
+func (BigRat) Read(arg interface{}) (Value, error) {
+	switch x := arg.(type) {
+	case *big.Rat:
+		return [][]*big.Rat{{copyRat(x)}}, nil
+	case *[]*big.Rat:
+		result := make([][]*big.Rat, len(*x))
+		for i, r := range *x {
+			result[i] = []*big.Rat{copyRat(r)}
+		}
+		return result, nil
+	case *[][]*big.Rat:
+		result := make([][]*big.Rat, len(*x))
+		for i, row := range *x {
+			if len(row) != len((*x)[0]) {
+				return nil, raggedError(len((*x)[0]), i, len(row))
+			}
+			for _, r := range row {
+				result[i] = append(result[i], copyRat(r))
+			}
+		}
+		return result, nil
+	default:
+		return nil, &UnsupportedTypeError{Value: arg}
+	}
+}
+
+// Stores the [][]*big.Rat v into arg.
+func (BigRat) Write(arg interface{}, v Value) error {
+	m, ok := v.([][]*big.Rat)
+	if !ok {
+		return &UnsupportedTypeError{Value: v}
+	}
+
+	switch x := arg.(type) {
+	case *big.Rat:
+		if len(m) != 1 || len(m[0]) != 1 {
+			return &DimensionError{Reason: "attempt to assign non-scalar value to scalar"}
+		}
+		x.Set(m[0][0])
+	case *[]*big.Rat:
+		if len(m) > 0 && len(m[0]) != 1 {
+			return &DimensionError{Reason: "attempt to assign non-vector value to vector"}
+		} else if *x == nil {
+			*x = make([]*big.Rat, len(m))
+		} else if len(m) != len(*x) {
+			return &DimensionError{Reason: "attempt to assign vectors of differing size"}
+		}
+		for i := range *x {
+			(*x)[i] = copyRat(m[i][0])
+		}
+	case *[][]*big.Rat:
+		*x = make([][]*big.Rat, len(m))
+		for i, row := range m {
+			for _, r := range row {
+				(*x)[i] = append((*x)[i], copyRat(r))
+			}
+		}
+	default:
+		return &UnsupportedTypeError{Value: arg}
+	}
+	return nil
+}
+
+// Returns the exact value of n as a 1-by-1 matrix.
+func (BigRat) Literal(n *Num) (Value, error) {
+	r, ok := new(big.Rat).SetString(n.Text)
+	if !ok {
+		return nil, &ArithmeticError{Reason: fmt.Sprintf("cannot represent %s exactly", n.Text)}
+	}
+	return [][]*big.Rat{{r}}, nil
+}
+
+// Computes "'" and ".'" (both transpose), "-" and "+".
+func (BigRat) Unary(op string, x Value) (Value, error) {
+	return fieldUnary[*big.Rat](ratField{}, op, x)
+}
+
+// Computes the arithmetic operators on rational matrices. Powers must be
+// integers.
+func (BigRat) Binary(op string, x, y Value) (Value, error) {
+	return fieldBinary[*big.Rat](ratField{}, op, x, y)
+}
+
+// Returns inv, det, trace and abs.
+func (BigRat) Funcs() map[string]ValueFunc {
+	funcs := fieldFuncs[*big.Rat](ratField{})
+	funcs["abs"] = unaryGrid(ratField{}, func(x grid[*big.Rat]) (grid[*big.Rat], error) {
+		return mapGrid(x, func(r *big.Rat) *big.Rat { return new(big.Rat).Abs(r) }), nil
+	})
+	return funcs
+}
+
+// Returns no functions, as the decompositions need square roots.
+func (BigRat) MultiFuncs() map[string]MultiValueFunc {
+	return map[string]MultiValueFunc{}
+}
+
+func (a BigFloat) field() floatField {
+	if a.Prec == 0 {
+		return floatField{256}
+	}
+	return floatField{a.Prec}
+}
+
+// Returns a copy of x rounded to the precision of f, treating nil as zero.
+func (f floatField) copy(x *big.Float) *big.Float {
+	if x == nil {
+		return f.new()
+	}
+	return f.new().Set(x)
+}
+
+// Converts arg into a [][]*big.Float, rounding each element to the
+// precision of a.
+func (a BigFloat) Read(arg interface{}) (Value, error) {
+	f := a.field()
+	switch x := arg.(type) {
+	case *big.Float:
+		return [][]*big.Float{{f.copy(x)}}, nil
+	case *[]*big.Float:
+		result := make([][]*big.Float, len(*x))
+		for i, v := range *x {
+			result[i] = []*big.Float{f.copy(v)}
+		}
+		return result, nil
+	case *[][]*big.Float:
+		result := make([][]*big.Float, len(*x))
+		for i, row := range *x {
+			if len(row) != len((*x)[0]) {
+				return nil, raggedError(len((*x)[0]), i, len(row))
+			}
+			for _, v := range row {
+				result[i] = append(result[i], f.copy(v))
+			}
+		}
+		return result, nil
+	default:
+		return nil, &UnsupportedTypeError{Value: arg}
+	}
+}
+
+// Stores the [][]*big.Float v into arg.
+func (a BigFloat) Write(arg interface{}, v Value) error {
+	m, ok := v.([][]*big.Float)
+	if !ok {
+		return &UnsupportedTypeError{Value: v}
+	}
+
+	switch x := arg.(type) {
+	case *big.Float:
+		if len(m) != 1 || len(m[0]) != 1 {
+			return &DimensionError{Reason: "attempt to assign non-scalar value to scalar"}
+		}
+		x.Set(m[0][0])
+	case *[]*big.Float:
+		if len(m) > 0 && len(m[0]) != 1 {
+			return &DimensionError{Reason: "attempt to assign non-vector value to vector"}
+		} else if *x == nil {
+			*x = make([]*big.Float, len(m))
+		} else if len(m) != len(*x) {
+			return &DimensionError{Reason: "attempt to assign vectors of differing size"}
+		}
+		for i := range *x {
+			(*x)[i] = a.field().copy(m[i][0])
+		}
+	case *[][]*big.Float:
+		*x = make([][]*big.Float, len(m))
+		for i, row := range m {
+			for _, f := range row {
+				(*x)[i] = append((*x)[i], a.field().copy(f))
+			}
+		}
+	default:
+		return &UnsupportedTypeError{Value: arg}
+	}
+	return nil
+}
+
+// Returns n, rounded to the precision of a, as a 1-by-1 matrix.
+func (a BigFloat) Literal(n *Num) (Value, error) {
+	f, _, err := big.ParseFloat(n.Text, 0, a.field().prec, big.ToNearestEven)
+	if err != nil {
+		return nil, &ArithmeticError{Reason: fmt.Sprintf("cannot represent %s", n.Text)}
+	} else if err := a.field().check(f); err != nil {
+		return nil, err
+	}
+	return [][]*big.Float{{f}}, nil
+}
+
+// Computes "'" and ".'" (both transpose), "-" and "+".
+func (a BigFloat) Unary(op string, x Value) (v Value, err error) {
+	defer recoverNaN(&err)
+	return fieldUnary[*big.Float](a.field(), op, x)
+}
+
+// Computes the arithmetic operators on matrices. Powers must be integers.
+func (a BigFloat) Binary(op string, x, y Value) (v Value, err error) {
+	defer recoverNaN(&err)
+	return fieldBinary[*big.Float](a.field(), op, x, y)
+}
+
+// Returns inv, det, trace, abs and sqrt.
+func (a BigFloat) Funcs() map[string]ValueFunc {
+	f := a.field()
+	funcs := fieldFuncs[*big.Float](f)
+	funcs["abs"] = unaryGrid(f, func(x grid[*big.Float]) (grid[*big.Float], error) {
+		return mapGrid(x, func(v *big.Float) *big.Float { return f.new().Abs(v) }), nil
+	})
+	funcs["sqrt"] = unaryGrid(f, func(x grid[*big.Float]) (grid[*big.Float], error) {
+		for _, row := range x {
+			for _, v := range row {
+				if v.Sign() < 0 {
+					return nil, &ArithmeticError{
+						Reason: "cannot take square root of negative number"}
+				}
+			}
+		}
+		return mapGrid(x, func(v *big.Float) *big.Float { return f.new().Sqrt(v) }), nil
+	})
+	for name, fn := range funcs {
+		fn := fn
+		funcs[name] = func(args ...Value) (v Value, err error) {
+			defer recoverNaN(&err)
+			return fn(args...)
+		}
+	}
+	return funcs
+}
+
+// Returns no functions; the decompositions are only available for Float64.
+func (a BigFloat) MultiFuncs() map[string]MultiValueFunc {
+	return map[string]MultiValueFunc{}
+}
+
+func (ratField) zero() *big.Rat {
+	return new(big.Rat)
+}
+
+func (ratField) one() *big.Rat {
+	return big.NewRat(1, 1)
+}
+
+func (ratField) add(x, y *big.Rat) *big.Rat {
+	return new(big.Rat).Add(x, y)
+}
+
+func (ratField) sub(x, y *big.Rat) *big.Rat {
+	return new(big.Rat).Sub(x, y)
+}
+
+func (ratField) mul(x, y *big.Rat) *big.Rat {
+	return new(big.Rat).Mul(x, y)
+}
+
+func (ratField) quo(x, y *big.Rat) (*big.Rat, error) {
+	if y.Sign() == 0 {
+		return nil, &ArithmeticError{Reason: "division by zero"}
+	}
+	return new(big.Rat).Quo(x, y), nil
+}
+
+func (ratField) neg(x *big.Rat) *big.Rat {
+	return new(big.Rat).Neg(x)
+}
+
+func (ratField) conj(x *big.Rat) *big.Rat {
+	return x
+}
+
+func (f ratField) pow(x, y *big.Rat) (*big.Rat, error) {
+	n, ok := f.integer(y)
+	if !ok {
+		return nil, &ArithmeticError{Reason: fmt.Sprintf(
+			"cannot raise to non-integer power %s", y.RatString())}
+	}
+	return powElem[*big.Rat](f, x, n)
+}
+
+func (ratField) isZero(x *big.Rat) bool {
+	return x.Sign() == 0
+}
+
+func (ratField) greater(x, y *big.Rat) bool {
+	return new(big.Rat).Abs(x).Cmp(new(big.Rat).Abs(y)) > 0
+}
+
+// The most bits in the numerator or denominator of a BigRat result, past
+// which a computation such as "3^2000000000" is abandoned.
+const maxRatBits = 1 << 20
+
+func (ratField) check(x *big.Rat) error {
+	if x.Num().BitLen() > maxRatBits || x.Denom().BitLen() > maxRatBits {
+		return &ArithmeticError{Reason: fmt.Sprintf(
+			"result has more than %d bits", maxRatBits)}
+	}
+	return nil
+}
+
+func (ratField) integer(r *big.Rat) (int, bool) {
+	if !r.IsInt() || !r.Num().IsInt64() || r.Num().Int64() != int64(int32(r.Num().Int64())) {
+		return 0, false
+	}
+	return int(r.Num().Int64()), true
+}
+
+// Returns a new zero of the precision of f.
+func (f floatField) new() *big.Float {
+	return new(big.Float).SetPrec(f.prec)
+}
+
+func (f floatField) zero() *big.Float {
+	return f.new()
+}
+
+func (f floatField) one() *big.Float {
+	return f.new().SetInt64(1)
+}
+
+func (f floatField) add(x, y *big.Float) *big.Float {
+	return f.new().Add(x, y)
+}
+
+func (f floatField) sub(x, y *big.Float) *big.Float {
+	return f.new().Sub(x, y)
+}
+
+func (f floatField) mul(x, y *big.Float) *big.Float {
+	return f.new().Mul(x, y)
+}
+
+// Divides x by y, refusing to divide by zero. Infinities can still arise
+// when the exponent overflows, as in "10^1000000000", and are caught by
+// check, or by recoverNaN should they first meet in "Inf - Inf".
+func (f floatField) quo(x, y *big.Float) (*big.Float, error) {
+	if y.Sign() == 0 {
+		return nil, &ArithmeticError{Reason: "division by zero"}
+	}
+	return f.new().Quo(x, y), nil
+}
+
+func (f floatField) neg(x *big.Float) *big.Float {
+	return f.new().Neg(x)
+}
+
+func (f floatField) conj(x *big.Float) *big.Float {
+	return x
+}
+
+func (f floatField) pow(x, y *big.Float) (*big.Float, error) {
+	n, ok := f.integer(y)
+	if !ok {
+		return nil, &ArithmeticError{Reason: fmt.Sprintf(
+			"cannot raise to non-integer power %s", y.String())}
+	}
+	return powElem[*big.Float](f, x, n)
+}
+
+func (f floatField) isZero(x *big.Float) bool {
+	return x.Sign() == 0
+}
+
+func (f floatField) greater(x, y *big.Float) bool {
+	return new(big.Float).Abs(x).Cmp(new(big.Float).Abs(y)) > 0
+}
+
+func (f floatField) integer(v *big.Float) (int, bool) {
+	if !v.IsInt() {
+		return 0, false
+	}
+	n, acc := v.Int64()
+	if acc != big.Exact || n != int64(int32(n)) {
+		return 0, false
+	}
+	return int(n), true
+}
+
+func (f floatField) check(x *big.Float) error {
+	if x.IsInf() {
+		return errFloatOverflow()
+	}
+	return nil
+}
+
+func errFloatOverflow() error {
+	return &ArithmeticError{Reason: "result overflows the exponent range of big.Float"}
+}
+
+// Recovers from the panic of big.Float on an undefined result, such as
+// Inf - Inf, which can only follow an overflow, and reports the overflow
+// in *err instead.
+func recoverNaN(err *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(big.ErrNaN); !ok {
+			panic(r)
+		}
+		*err = errFloatOverflow()
+	}
+}
@@ -0,0 +1,208 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"math/big"
+	"testing"
+)
+
+func TestBigRat(t *testing.T) {
+	env := NewAlgebraEnv(BigRat{})
+	half, third := big.NewRat(1, 2), big.NewRat(1, 3)
+	A := [][]*big.Rat{
+		{big.NewRat(2, 1), big.NewRat(1, 1)},
+		{big.NewRat(1, 1), big.NewRat(3, 1)},
+	}
+	b := []*big.Rat{big.NewRat(3, 1), big.NewRat(5, 1)}
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Result string
+	}{
+		{"y = 0.1 + 0.2", nil, "[[3/10]]"},
+		{"y = h + t", []interface{}{half, third}, "[[5/6]]"},
+		{"y = A \\ b", []interface{}{&A, &b}, "[[4/5] [7/5]]"},
+		{"y = inv A", []interface{}{&A}, "[[3/5 -1/5] [-1/5 2/5]]"},
+		{"y = A^-1 * A", []interface{}{&A}, "[[1 0] [0 1]]"},
+		{"y = det(A) / 3^2", []interface{}{&A}, "[[5/9]]"},
+		{"y = inv(A) * det(A) + A", []interface{}{&A}, "[[5 0] [0 5]]"},
+		{"y = b' ./ A .^ 2", []interface{}{&b, &A}, "[[3/4 5] [3 5/9]]"},
+		{"y = abs(-h) - 1e-3", []interface{}{half}, "[[499/1000]]"},
+		{"y = 0x10 / 3", nil, "[[16/3]]"},
+		{"y = 1e400 / 1e399", nil, "[[10]]"},
+	}
+
+	for _, test := range tests {
+		var y [][]*big.Rat
+		if err := env.Eval(test.Source, append([]interface{}{&y}, test.Args...)...); err != nil {
+			t.Errorf("%s, while evaluating %s", err, test.Source)
+			continue
+		}
+
+		got := "["
+		for i, row := range y {
+			if i > 0 {
+				got += " "
+			}
+			got += "["
+			for j, r := range row {
+				if j > 0 {
+					got += " "
+				}
+				got += r.RatString()
+			}
+			got += "]"
+		}
+		got += "]"
+		if got != test.Result {
+			t.Errorf("evaluating %s\ngot       %s\nexpecting %s", test.Source, got, test.Result)
+		}
+	}
+
+	// inputs are left untouched, and scalars are written in place
+	y := new(big.Rat)
+	if err := env.Eval("y = -h * 2", y, half); err != nil {
+		t.Fatal(err)
+	} else if y.RatString() != "-1" || half.RatString() != "1/2" {
+		t.Errorf("got y = %s, h = %s", y, half)
+	}
+
+	// matrices assigned twice do not share their elements
+	var p, q [][]*big.Rat
+	if err := env.Eval("p, q = A, A", &p, &q, &A); err != nil {
+		t.Fatal(err)
+	}
+	p[0][0].SetInt64(5)
+	if q[0][0].RatString() != "2" {
+		t.Errorf("setting p[0][0] changed q to %v", q)
+	}
+
+	errors := []struct {
+		Source string
+		Error  string
+	}{
+		{"y = h / 0", "division by zero, in (h / 0) at 1:5"},
+		{"y = h ^ h", "cannot raise to non-integer power 1/2, in (h ^ h) at 1:5"},
+		{"y = (6*h)^2000000000", "result has more than 1048576 bits, in ((6 * h) ^ 2000000000) at 1:5"},
+		{"y = (h/3)^-2000000000", "result has more than 1048576 bits, in ((h / 3) ^ (- 2000000000)) at 1:5"},
+	}
+	for _, test := range errors {
+		if err := env.Eval(test.Source, y, half); err == nil || err.Error() != test.Error {
+			t.Errorf("evaluating %s\ngot       %v\nexpecting %s", test.Source, err, test.Error)
+		}
+	}
+}
+
+func TestBigFloat(t *testing.T) {
+	env := NewAlgebraEnv(BigFloat{Prec: 200})
+	two := big.NewFloat(2)
+
+	y := new(big.Float)
+	if err := env.Eval("y = sqrt(x) ^ 2 - x", y, two); err != nil {
+		t.Fatal(err)
+	}
+	if y.Prec() != 200 {
+		t.Errorf("got precision %d, expecting 200", y.Prec())
+	}
+	if exp := y.MantExp(nil); y.Sign() != 0 && exp > -190 {
+		t.Errorf("got sqrt(2)^2 - 2 = %s, expecting it within 2^-190", y.Text('g', 10))
+	}
+
+	A := [][]*big.Float{
+		{big.NewFloat(4), big.NewFloat(7)},
+		{big.NewFloat(2), big.NewFloat(6)},
+	}
+	var B [][]*big.Float
+	if err := env.Eval("B = A * inv(A)", &B, &A); err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range B {
+		for j, v := range row {
+			diff := new(big.Float).Sub(v, big.NewFloat(float64(1-(i+j)%2)))
+			if diff.Sign() != 0 && diff.MantExp(nil) > -190 {
+				t.Errorf("got B[%d][%d] = %s", i, j, v.Text('g', 20))
+			}
+		}
+	}
+
+	if err := env.Eval("y = x / (x - 2)", y, two); err == nil {
+		t.Errorf("expected an error when dividing by zero")
+	}
+	if _, ok := env.Eval("y = sqrt(-x)", y, two).(*ArithmeticError); !ok {
+		t.Errorf("expected an *ArithmeticError for the square root of -2")
+	}
+
+	// literals too large for a float64 are parsed at full precision
+	if err := env.Eval("y = 1e100000000 / 1e99999999 - 10", y); err != nil {
+		t.Error(err)
+	} else if y.Sign() != 0 && y.MantExp(nil) > -190 {
+		t.Errorf("got 1e100000000 / 1e99999999 - 10 = %s, expecting it within 2^-190", y.Text('g', 10))
+	}
+
+	// matrices assigned twice do not share their elements
+	var p, q [][]*big.Float
+	if err := env.Eval("p, q = A, A", &p, &q, &A); err != nil {
+		t.Fatal(err)
+	}
+	p[0][0].SetInt64(5)
+	if q[0][0].Cmp(big.NewFloat(4)) != 0 {
+		t.Errorf("setting p[0][0] changed q to %v", q)
+	}
+
+	// overflowing the exponent gives an error, rather than infinities
+	huge := new(big.Float).SetMantExp(big.NewFloat(0.5), big.MaxExp)
+	row := [][]*big.Float{{huge, huge}}
+	col := [][]*big.Float{{huge}, {new(big.Float).Neg(huge)}}
+	overflows := []struct {
+		Source string
+		Args   []interface{}
+	}{
+		{"y = 10^1000000000 - 10^1000000000", nil},
+		{"y = 1e1000000000", nil},
+		{"y = x * x", []interface{}{huge}},
+		{"y = x + x", []interface{}{huge}},
+		{"y = A * B", []interface{}{&row, &col}},
+		{"y = det(B * B')", []interface{}{&col}},
+	}
+	for _, test := range overflows {
+		err := env.Eval(test.Source, append([]interface{}{y}, test.Args...)...)
+		if _, ok := err.(*ArithmeticError); !ok {
+			t.Errorf("evaluating %s: got %v, expecting an *ArithmeticError", test.Source, err)
+		}
+	}
+}
+
+func TestCompileEquations(t *testing.T) {
+	tree, err := PEMDAS.Parse("y = x / 10 * 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := NewEnv().CompileEquations(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, x := 0.0, 1.0
+	prog.MustRun(&y, &x)
+	if y == 0.3 {
+		t.Errorf("expected rounding error from float64, got exactly %v", y)
+	}
+
+	exact, err := NewAlgebraEnv(BigRat{}).CompileEquations(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yr, xr := new(big.Rat), big.NewRat(1, 1)
+	exact.MustRun(yr, xr)
+	if yr.Cmp(big.NewRat(3, 10)) != 0 {
+		t.Errorf("got y = %s, expecting 3/10", yr)
+	}
+	if got := exact.String(); got != "y = ((x / 10) * 3)" {
+		t.Errorf("got source %s", got)
+	}
+
+	if _, err := NewEnv().CompileEquations(); err == nil {
+		t.Errorf("expected an error compiling no equations")
+	}
+}
@@ -0,0 +1,193 @@
+package mast
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Complex128 is an Algebra over complex matrices, whose values are
+// [][]complex128. It accepts *complex128, *[]complex128 and *[][]complex128
+// arguments, and defines the imaginary unit as both "i" and "j", as in
+// "z = 3 + 4i". The operator "'" takes the conjugate transpose, while ".'"
+// transposes without conjugating.
+type Complex128 struct{}
+
+type complexField struct{}
+
+// Converts arg into a [][]complex128.
+func (Complex128) Read(arg interface{}) (Value, error) {
+	switch x := arg.(type) {
+	case *complex128:
+		return [][]complex128{{*x}}, nil
+	case *[]complex128:
+		result := make([][]complex128, len(*x))
+		for i, v := range *x {
+			result[i] = []complex128{v}
+		}
+		return result, nil
+	case *[][]complex128:
+		result := make([][]complex128, len(*x))
+		for i, row := range *x {
+			if len(row) != len((*x)[0]) {
+				return nil, raggedError(len((*x)[0]), i, len(row))
+			}
+			result[i] = append([]complex128{}, row...)
+		}
+		return result, nil
+	default:
+		return nil, &UnsupportedTypeError{Value: arg}
+	}
+}
+
+// Stores the [][]complex128 v into arg.
+func (Complex128) Write(arg interface{}, v Value) error {
+	m, ok := v.([][]complex128)
+	if !ok {
+		return &UnsupportedTypeError{Value: v}
+	}
+	rows, cols := len(m), 0
+	if rows > 0 {
+		cols = len(m[0])
+	}
+
+	switch x := arg.(type) {
+	case *complex128:
+		if rows != 1 || cols != 1 {
+			return &DimensionError{Reason: "attempt to assign non-scalar value to scalar"}
+		}
+		*x = m[0][0]
+	case *[]complex128:
+		if cols != 1 {
+			return &DimensionError{Reason: "attempt to assign non-vector value to vector"}
+		} else if *x == nil {
+			*x = make([]complex128, rows)
+		} else if rows != len(*x) {
+			return &DimensionError{Reason: "attempt to assign vectors of differing size"}
+		}
+		for i := range *x {
+			(*x)[i] = m[i][0]
+		}
+	case *[][]complex128:
+		*x = make([][]complex128, rows)
+		for i, row := range m {
+			(*x)[i] = append([]complex128{}, row...)
+		}
+	default:
+		return &UnsupportedTypeError{Value: arg}
+	}
+	return nil
+}
+
+// Returns n as a 1-by-1 matrix.
+func (Complex128) Literal(n *Num) (Value, error) {
+	if math.IsInf(n.Value, 0) {
+		return nil, errTooLarge(n)
+	}
+	return [][]complex128{{complex(n.Value, 0)}}, nil
+}
+
+// Returns the imaginary unit for "i" and "j".
+func (Complex128) Constant(name string) (Value, bool) {
+	if name == "i" || name == "j" {
+		return [][]complex128{{1i}}, true
+	}
+	return nil, false
+}
+
+// Computes "'" (conjugate transpose), ".'" (transpose), "-" and "+".
+func (Complex128) Unary(op string, x Value) (Value, error) {
+	return fieldUnary[complex128](complexField{}, op, x)
+}
+
+// Computes the arithmetic operators on complex matrices.
+func (Complex128) Binary(op string, x, y Value) (Value, error) {
+	return fieldBinary[complex128](complexField{}, op, x, y)
+}
+
+// Returns inv, det and trace, along with the elementwise functions conj,
+// real, imag, abs, exp, log, sqrt, sin and cos.
+func (Complex128) Funcs() map[string]ValueFunc {
+	funcs := fieldFuncs[complex128](complexField{})
+	for name, fn := range map[string]func(complex128) complex128{
+		"conj": cmplx.Conj,
+		"real": func(z complex128) complex128 { return complex(real(z), 0) },
+		"imag": func(z complex128) complex128 { return complex(imag(z), 0) },
+		"abs":  func(z complex128) complex128 { return complex(cmplx.Abs(z), 0) },
+		"exp":  cmplx.Exp,
+		"log":  cmplx.Log,
+		"sqrt": cmplx.Sqrt,
+		"sin":  cmplx.Sin,
+		"cos":  cmplx.Cos,
+	} {
+		fn := fn
+		funcs[name] = unaryGrid(complexField{}, func(x grid[complex128]) (grid[complex128], error) {
+			return mapGrid(x, fn), nil
+		})
+	}
+	return funcs
+}
+
+// Returns no functions, as none of the decompositions support complex
+// matrices.
+func (Complex128) MultiFuncs() map[string]MultiValueFunc {
+	return map[string]MultiValueFunc{}
+}
+
+func (complexField) zero() complex128 {
+	return 0
+}
+
+func (complexField) one() complex128 {
+	return 1
+}
+
+func (complexField) add(x, y complex128) complex128 {
+	return x + y
+}
+
+func (complexField) sub(x, y complex128) complex128 {
+	return x - y
+}
+
+func (complexField) mul(x, y complex128) complex128 {
+	return x * y
+}
+
+func (complexField) quo(x, y complex128) (complex128, error) {
+	return x / y, nil
+}
+
+func (complexField) neg(x complex128) complex128 {
+	return -x
+}
+
+func (complexField) conj(x complex128) complex128 {
+	return cmplx.Conj(x)
+}
+
+func (complexField) pow(x, y complex128) (complex128, error) {
+	// multiply out whole powers, which is exact where cmplx.Pow rounds
+	if n, ok := (complexField{}).integer(y); ok && n >= 0 {
+		return powElem[complex128](complexField{}, x, n)
+	}
+	return cmplx.Pow(x, y), nil
+}
+
+func (complexField) isZero(x complex128) bool {
+	return x == 0
+}
+
+func (complexField) greater(x, y complex128) bool {
+	return cmplx.Abs(x) > cmplx.Abs(y)
+}
+
+func (complexField) integer(z complex128) (int, bool) {
+	if imag(z) != 0 || real(z) != math.Trunc(real(z)) || math.Abs(real(z)) > math.MaxInt32 {
+		return 0, false
+	}
+	return int(real(z)), true
+}
+
+func (complexField) check(x complex128) error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"math/cmplx"
+	"testing"
+)
+
+func TestComplex(t *testing.T) {
+	env := NewAlgebraEnv(Complex128{})
+	A := [][]complex128{{1 + 2i, 3}, {-1i, 2}}
+	b := []complex128{1, 1i}
+	z := complex128(2 - 1i)
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Result [][]complex128
+	}{
+		{"y = 3 + 4i", nil, [][]complex128{{3 + 4i}}},
+		{"y = i * j", nil, [][]complex128{{-1}}},
+		{"y = z * (2 + i)", []interface{}{&z}, [][]complex128{{5}}},
+		{"y = A'", []interface{}{&A}, [][]complex128{{1 - 2i, 1i}, {3, 2}}},
+		{"y = A.'", []interface{}{&A}, [][]complex128{{1 + 2i, -1i}, {3, 2}}},
+		{"y = b' * b", []interface{}{&b}, [][]complex128{{2}}},
+		{"y = b.' * b", []interface{}{&b}, [][]complex128{{0}}},
+		{"y = A * (A \\ b)", []interface{}{&A, &b}, [][]complex128{{1}, {1i}}},
+		{"y = (b.' / A) * A", []interface{}{&b, &A}, [][]complex128{{1, 1i}}},
+		{"y = A^-2 * A^2", []interface{}{&A}, [][]complex128{{1, 0}, {0, 1}}},
+		{"y = i^2 + 1", nil, [][]complex128{{0}}},
+		{"y = b .* b + 1", []interface{}{&b}, [][]complex128{{2}, {0}}},
+		{"y = det A", []interface{}{&A}, [][]complex128{{2 + 7i}}},
+		{"y = exp(i * 3.141592653589793)", nil, [][]complex128{{-1}}},
+		{"y = abs(3 + 4j)", nil, [][]complex128{{5}}},
+		{"y = conj(z) - real(z) + imag(z) i", []interface{}{&z}, [][]complex128{{0}}},
+		{"i = 2; y = i * z", []interface{}{&z}, [][]complex128{{4 - 2i}}},
+	}
+
+	for _, test := range tests {
+		var y [][]complex128
+		if err := env.Eval(test.Source, append([]interface{}{&y}, test.Args...)...); err != nil {
+			t.Errorf("%s, while evaluating %s", err, test.Source)
+			continue
+		}
+
+		ok := len(y) == len(test.Result)
+		for i := 0; ok && i < len(y); i++ {
+			ok = len(y[i]) == len(test.Result[i])
+			for j := 0; ok && j < len(y[i]); j++ {
+				ok = cmplx.Abs(y[i][j]-test.Result[i][j]) < 1e-9
+			}
+		}
+		if !ok {
+			t.Errorf("evaluating %s\ngot       %v\nexpecting %v", test.Source, y, test.Result)
+		}
+	}
+
+	// i and j are constants, not variables
+	prog, err := env.Compile("y = 2i * x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars := prog.Vars(); len(vars) != 2 || vars[1] != "x" {
+		t.Errorf("got variables %v, expecting [y x]", vars)
+	}
+
+	// matrices assigned twice do not share their rows
+	var p, q [][]complex128
+	if err := env.Eval("p, q = A, A", &p, &q, &A); err != nil {
+		t.Fatal(err)
+	}
+	p[0][0] = 5
+	if q[0][0] != 1+2i {
+		t.Errorf("setting p[0][0] changed q to %v", q)
+	}
+
+	var y complex128
+	if _, ok := env.Eval("y = b", &y, &b).(*DimensionError); !ok {
+		t.Errorf("expected a *DimensionError assigning a vector to a scalar")
+	}
+	if _, ok := env.Eval("y = z ? 1 : 2", &y, &z).(*UnknownOperatorError); !ok {
+		t.Errorf("expected an *UnknownOperatorError for a conditional")
+	}
+	f := 1.0
+	if _, ok := env.Eval("y = f", &y, &f).(*UnsupportedTypeError); !ok {
+		t.Errorf("expected an *UnsupportedTypeError for a *float64")
+	}
+}
@@ -0,0 +1,271 @@
+package mast
+
+import (
+	"math"
+	"sort"
+)
+
+// Matrix decompositions, each returning several values, as in "q, r = qr A".
+
+func sign(x float64) float64 {
+	if x < 0 {
+		return -1
+	}
+	return 1
+}
+
+// Rotates columns p and q of a by the given cosine and sine.
+func rotateCols(a Matrix, p, q int, c, s float64) {
+	for k := 0; k < a.rows; k++ {
+		ap, aq := a.At(k, p), a.At(k, q)
+		a.Set(k, p, c*ap-s*aq)
+		a.Set(k, q, s*ap+c*aq)
+	}
+}
+
+// Rotates rows p and q of a by the given cosine and sine.
+func rotateRows(a Matrix, p, q int, c, s float64) {
+	rotateCols(a.T(), p, q, c, s)
+}
+
+// Returns the sizes of x as two scalars.
+func sizeMat(x Matrix) ([]Matrix, error) {
+	rows, cols := x.Dims()
+	return []Matrix{scalarMat(float64(rows)), scalarMat(float64(cols))}, nil
+}
+
+// Returns the size of x as a 1-by-2 row vector.
+func sizeRow(x Matrix) (Matrix, error) {
+	rows, cols := x.Dims()
+	return NewMatrix(1, 2, []float64{float64(rows), float64(cols)}), nil
+}
+
+// Computes Q and R, where Q is orthogonal, R is upper triangular, and
+// Q * R = x, using Householder reflections.
+func qrMat(x Matrix) ([]Matrix, error) {
+	m, n := x.Dims()
+	r, q := copyMat(x), identity(m)
+
+	for k := 0; k < n && k < m-1; k++ {
+		norm := 0.0
+		for i := k; i < m; i++ {
+			norm += r.At(i, k) * r.At(i, k)
+		}
+		if norm = math.Sqrt(norm); norm == 0 {
+			continue
+		}
+
+		// reflect r[k:, k] onto -sign(r[k][k]) * norm * e_k
+		v := make([]float64, m)
+		for i := k; i < m; i++ {
+			v[i] = r.At(i, k)
+		}
+		v[k] += sign(r.At(k, k)) * norm
+
+		vv := 0.0
+		for i := k; i < m; i++ {
+			vv += v[i] * v[i]
+		}
+
+		for j := 0; j < n; j++ {
+			f := 0.0
+			for i := k; i < m; i++ {
+				f += v[i] * r.At(i, j)
+			}
+			for i := k; i < m; i++ {
+				r.Set(i, j, r.At(i, j)-2*f/vv*v[i])
+			}
+		}
+		for i := 0; i < m; i++ {
+			f := 0.0
+			for j := k; j < m; j++ {
+				f += q.At(i, j) * v[j]
+			}
+			for j := k; j < m; j++ {
+				q.Set(i, j, q.At(i, j)-2*f/vv*v[j])
+			}
+		}
+		for i := k + 1; i < m; i++ {
+			r.Set(i, k, 0)
+		}
+	}
+	return []Matrix{q, r}, nil
+}
+
+// Computes L, U and P, where L is unit lower triangular, U is upper
+// triangular, P is a permutation, and P * x = L * U.
+func luMat(x Matrix) ([]Matrix, error) {
+	n, err := square(x, "factor")
+	if err != nil {
+		return nil, err
+	}
+
+	u, l, p := copyMat(x), identity(n), identity(n)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(u.At(row, col)) > math.Abs(u.At(pivot, col)) {
+				pivot = row
+			}
+		}
+		swapRows(u, col, pivot)
+		swapRows(p, col, pivot)
+		for k := 0; k < col; k++ {
+			a, b := l.At(col, k), l.At(pivot, k)
+			l.Set(col, k, b)
+			l.Set(pivot, k, a)
+		}
+
+		if u.At(col, col) == 0 {
+			continue
+		}
+		for row := col + 1; row < n; row++ {
+			f := u.At(row, col) / u.At(col, col)
+			l.Set(row, col, f)
+			for k := col; k < n; k++ {
+				u.Set(row, k, u.At(row, k)-f*u.At(col, k))
+			}
+			u.Set(row, col, 0)
+		}
+	}
+	return []Matrix{l, u, p}, nil
+}
+
+// Computes V and D, where D is a diagonal matrix of the eigenvalues of x in
+// ascending order, and the columns of V are the corresponding eigenvectors,
+// so that x * V = V * D. Only symmetric matrices are supported, using the
+// cyclic Jacobi method.
+func eigMat(x Matrix) ([]Matrix, error) {
+	n, err := square(x, "find eigenvalues of")
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < i; j++ {
+			a, b := x.At(i, j), x.At(j, i)
+			if math.Abs(a-b) > 1e-12*(math.Abs(a)+math.Abs(b)) {
+				return nil, &ArithmeticError{
+					Reason: "cannot find eigenvalues of non-symmetric matrix"}
+			}
+		}
+	}
+
+	d, v := copyMat(x), identity(n)
+	for sweep := 0; sweep < 100; sweep++ {
+		rotated := false
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if math.Abs(d.At(p, q)) <= 1e-15*(math.Abs(d.At(p, p))+math.Abs(d.At(q, q))) {
+					continue
+				}
+				rotated = true
+
+				theta := (d.At(q, q) - d.At(p, p)) / (2 * d.At(p, q))
+				t := sign(theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				rotateCols(d, p, q, c, s)
+				rotateRows(d, p, q, c, s)
+				rotateCols(v, p, q, c, s)
+			}
+		}
+		if !rotated {
+			break
+		}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return d.At(order[i], order[i]) < d.At(order[j], order[j])
+	})
+
+	vecs, vals := newMat(n, n), newMat(n, n)
+	for j, k := range order {
+		vals.Set(j, j, d.At(k, k))
+		for i := 0; i < n; i++ {
+			vecs.Set(i, j, v.At(i, k))
+		}
+	}
+	return []Matrix{vecs, vals}, nil
+}
+
+// Computes the economy-size singular value decomposition U, S and V, where
+// S is a diagonal matrix of the singular values in descending order, and
+// U * S * V' = x. For an m-by-n matrix with m >= n, U is m-by-n and both S
+// and V are n-by-n. This uses the one-sided Jacobi method.
+func svdMat(x Matrix) ([]Matrix, error) {
+	m, n := x.Dims()
+	if m < n {
+		// decompose x' = V * S * U' instead
+		result, err := svdMat(x.T())
+		if err != nil {
+			return nil, err
+		}
+		return []Matrix{result[2], result[1], result[0]}, nil
+	}
+
+	u, v := copyMat(x), identity(n)
+	for sweep := 0; sweep < 100; sweep++ {
+		rotated := false
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				alpha, beta, gamma := 0.0, 0.0, 0.0
+				for i := 0; i < m; i++ {
+					alpha += u.At(i, p) * u.At(i, p)
+					beta += u.At(i, q) * u.At(i, q)
+					gamma += u.At(i, p) * u.At(i, q)
+				}
+				if math.Abs(gamma) <= 1e-15*math.Sqrt(alpha*beta) {
+					continue
+				}
+				rotated = true
+
+				zeta := (beta - alpha) / (2 * gamma)
+				t := sign(zeta) / (math.Abs(zeta) + math.Sqrt(1+zeta*zeta))
+				c := 1 / math.Sqrt(1+t*t)
+				s := c * t
+
+				rotateCols(u, p, q, c, s)
+				rotateCols(v, p, q, c, s)
+			}
+		}
+		if !rotated {
+			break
+		}
+	}
+
+	sigma := make([]float64, n)
+	for j := range sigma {
+		for i := 0; i < m; i++ {
+			sigma[j] += u.At(i, j) * u.At(i, j)
+		}
+		sigma[j] = math.Sqrt(sigma[j])
+		for i := 0; i < m && sigma[j] != 0; i++ {
+			u.Set(i, j, u.At(i, j)/sigma[j])
+		}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sigma[order[i]] > sigma[order[j]]
+	})
+
+	us, s, vs := newMat(m, n), newMat(n, n), newMat(n, n)
+	for j, k := range order {
+		s.Set(j, j, sigma[k])
+		for i := 0; i < m; i++ {
+			us.Set(i, j, u.At(i, k))
+		}
+		for i := 0; i < n; i++ {
+			vs.Set(i, j, v.At(i, k))
+		}
+	}
+	return []Matrix{us, s, vs}, nil
+}
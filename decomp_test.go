@@ -0,0 +1,101 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"testing"
+)
+
+func TestDecompositions(t *testing.T) {
+	A := [][]float64{{4, 1, 2}, {1, 3, 0}, {2, 0, 5}}
+	B := [][]float64{{1, 2}, {3, 4}, {5, 6}}
+	I := [][]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+	var q, r, l, u, p, v, d, s, w, y [][]float64
+
+	MustEval("q, r = qr(A)", &q, &r, &A)
+	if MustEval("y = q * r", &y, &q, &r); !closeTo(y, A) {
+		t.Errorf("q * r = %v, expecting %v", y, A)
+	}
+	if MustEval("y = q' * q", &y, &q); !closeTo(y, I) {
+		t.Errorf("q is not orthogonal: q' * q = %v", y)
+	}
+	if r[1][0] != 0 || r[2][0] != 0 || r[2][1] != 0 {
+		t.Errorf("r is not upper triangular: %v", r)
+	}
+
+	MustEval("l, u, p = lu(A)", &l, &u, &p, &A)
+	if MustEval("y = p * A - l * u", &y, &p, &A, &l, &u); !closeTo(y, make3(0)) {
+		t.Errorf("p * A - l * u = %v", y)
+	}
+
+	MustEval("v, d = eig(A)", &v, &d, &A)
+	if MustEval("y = A * v - v * d", &y, &A, &v, &d); !closeTo(y, make3(0)) {
+		t.Errorf("A * v - v * d = %v", y)
+	}
+	if d[0][0] > d[1][1] || d[1][1] > d[2][2] {
+		t.Errorf("eigenvalues are not ascending: %v", d)
+	}
+
+	for _, M := range [][][]float64{B, transpose(B)} {
+		MustEval("u, s, w = svd(M)", &u, &s, &w, &M)
+		if MustEval("y = u * s * w'", &y, &u, &s, &w); !closeTo(y, M) {
+			t.Errorf("u * s * w' = %v, expecting %v", y, M)
+		}
+		if s[0][0] < s[1][1] {
+			t.Errorf("singular values are not descending: %v", s)
+		}
+	}
+
+	rows, cols := 0.0, 0.0
+	MustEval("rows, cols = size(B)", &rows, &cols, &B)
+	if rows != 3 || cols != 2 {
+		t.Errorf("size(B) = %v, %v", rows, cols)
+	}
+	MustEval("y = size(B)", &y, &B)
+	if !closeTo(y, [][]float64{{3, 2}}) {
+		t.Errorf("size(B) = %v", y)
+	}
+}
+
+func make3(x float64) [][]float64 {
+	return [][]float64{{x, x, x}, {x, x, x}, {x, x, x}}
+}
+
+func transpose(x [][]float64) [][]float64 {
+	var y [][]float64
+	MustEval("y = x'", &y, &x)
+	return y
+}
+
+func TestMultipleAssignment(t *testing.T) {
+	a, b := 1.0, 2.0
+	MustEval("a, b = b, a + b", &a, &b)
+	if a != 2 || b != 3 {
+		t.Errorf("got a, b = %v, %v; expecting 2, 3", a, b)
+	}
+
+	prog := MustCompile("a, b = b, a")
+	if vars := prog.Vars(); len(vars) != 2 {
+		t.Errorf("got vars %v, expecting [a b]", vars)
+	}
+
+	A := [][]float64{{1, 2}, {3, 4}}
+	var q, r, x [][]float64
+	errors := []error{
+		Eval("q, r, x = qr(A)", &q, &r, &x, &A),
+		Eval("q, r = A, A, A", &q, &r, &A),
+		Eval("q = qr(A)", &q, &A),
+	}
+	for _, err := range errors {
+		if _, ok := err.(*ArgumentError); !ok {
+			t.Errorf("expected an *ArgumentError, got %v", err)
+		}
+	}
+
+	if err := Eval("q, q = qr(A)", &q, &A); err == nil {
+		t.Errorf("expected an error when assigning q twice")
+	}
+	if err := Eval("q + r = qr(A)", &q, &r, &A); err == nil {
+		t.Errorf("expected an error when assigning to q + r")
+	}
+}
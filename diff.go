@@ -0,0 +1,364 @@
+package mast
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// A DiffError indicates that Expr cannot be differentiated, such as when it
+// calls max or eye, or uses an operator with no derivative.
+type DiffError struct {
+	Expr   Expr
+	Reason string
+}
+
+// Represent this DiffError as a string.
+func (d DiffError) Error() string {
+	return d.Reason + where(d.Expr)
+}
+
+// Returns the derivative of e with respect to the scalar variable wrt, as a
+// new Expr. Every other variable is held constant. Applying a built-in
+// function uses the chain rule, and applying any other name multiplies, just
+// as when evaluating. Built-in functions with no derivative, such as max, eye
+// or qr, give a *DiffError unless their arguments are constant.
+//
+// The derivative is taken elementwise, so for a vector or matrix wrt, whose
+// change cannot be written as the scalar 1, use Differential instead.
+func Diff(e Expr, wrt string) (Expr, error) {
+	return diff(e, wrt, literal(1))
+}
+
+// Returns the differential of e as the variable wrt changes by dx, which
+// may be a vector or matrix the size of wrt, such as a variable standing for
+// the direction of the change. Matrix products keep their order, so that
+//
+//	A * B    becomes  dA * B + A * dB
+//	A'       becomes  dA'
+//	inv(A)   becomes  -(inv(A) * dA * inv(A))
+//	det(A)   becomes  det(A) * trace(inv(A) * dA)
+//
+// and likewise for "/" and "\". For instance, "x' * A * x" becomes
+// "dx' * A * x + x' * A * dx", whose value with dx set to each column of the
+// identity in turn is each element of the gradient. Since "A^n" may only be
+// evaluated for integer n, it is differentiated as n * A^(n-1) * dA, which
+// assumes that A commutes with dA, as it does for scalars; use ".^" for
+// elementwise powers. Terms known to be zero or one are simplified away as
+// they are built. Diff(e, wrt) is Differential(e, wrt, 1).
+func Differential(e Expr, wrt string, dx Expr) (Expr, error) {
+	return diff(e, wrt, dx)
+}
+
+func diff(e Expr, wrt string, dx Expr) (Expr, error) {
+	switch e := e.(type) {
+	case *Var:
+		if e.Name == wrt {
+			return dx, nil
+		}
+		return literal(0), nil
+
+	case *Num:
+		return literal(0), nil
+
+	case *Unary:
+		d, err := diff(e.Elem, wrt, dx)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case "-":
+			return negate(d), nil
+		case "+":
+			return d, nil
+		case "'", ".'":
+			if _, ok := literalValue(d); ok {
+				return d, nil
+			}
+			return &Unary{Op: e.Op, Elem: d}, nil
+		case "!":
+			return literal(0), nil
+		}
+		return nil, &DiffError{e, fmt.Sprintf("cannot differentiate operator %#v", e.Op)}
+
+	case *Binary:
+		return diffBinary(e, wrt, dx)
+
+	case *Apply:
+		if v, ok := e.Operator.(*Var); ok && isBuiltin(v.Name) {
+			args := arguments(e.Operand)
+			dargs := []Expr{}
+			zero := true
+			for _, arg := range args {
+				d, err := diff(arg, wrt, dx)
+				if err != nil {
+					return nil, err
+				}
+				dargs = append(dargs, d)
+				zero = zero && isLiteral(d, 0)
+			}
+			if zero {
+				return literal(0), nil
+			} else if derivatives[v.Name] == nil {
+				return nil, &DiffError{e, fmt.Sprintf("cannot differentiate %s", v.Name)}
+			}
+			return derivatives[v.Name](e, args, dargs)
+		}
+
+		// treat all other application as multiplication
+		return diffBinary(&Binary{Op: "*", Left: e.Operator, Right: e.Operand}, wrt, dx)
+
+	case *Cond:
+		dthen, err := diff(e.Then, wrt, dx)
+		if err != nil {
+			return nil, err
+		}
+		delse, err := diff(e.Else, wrt, dx)
+		if err != nil {
+			return nil, err
+		}
+		if isLiteral(dthen, 0) && isLiteral(delse, 0) {
+			return literal(0), nil
+		}
+		return &Cond{Test: e.Test, Then: dthen, Else: delse}, nil
+
+	case *Equation:
+		return nil, &DiffError{e, "cannot differentiate an equation"}
+
+	default:
+		return nil, fmt.Errorf("strange Expr: %#v", e)
+	}
+}
+
+func diffBinary(e *Binary, wrt string, dx Expr) (Expr, error) {
+	switch e.Op {
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		return literal(0), nil
+	}
+
+	dl, err := diff(e.Left, wrt, dx)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := diff(e.Right, wrt, dx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case ",":
+		return &Binary{Op: ",", Left: dl, Right: dr}, nil
+	case "+":
+		return add(dl, dr), nil
+	case "-":
+		return subtract(dl, dr), nil
+	case "*", ".*":
+		return add(multiply(e.Op, dl, e.Right), multiply(e.Op, e.Left, dr)), nil
+	case "/":
+		// (dA - (A / B) * dB) / B
+		return divide("/", subtract(dl, multiply("*", e, dr)), e.Right), nil
+	case "./":
+		return divide("./", subtract(dl, multiply(".*", e, dr)), e.Right), nil
+	case "\\":
+		// A \ (dB - dA * (A \ B))
+		return divide("\\", e.Left, subtract(dr, multiply("*", dl, e))), nil
+	case "^", ".^":
+		mul := "*"
+		if e.Op == ".^" {
+			mul = ".*"
+		}
+		// n * A^(n-1) * dA + A^n * log(A) * dn
+		var power Expr = &Binary{Op: e.Op, Left: e.Left, Right: subtract(e.Right, literal(1))}
+		if n, ok := literalValue(e.Right); ok && n == 1 {
+			power = literal(1)
+		} else if ok && n == 2 {
+			power = e.Left
+		}
+		base := multiply(mul, multiply(mul, e.Right, power), dl)
+		exponent := multiply(mul, multiply(mul, e, call("log", e.Left)), dr)
+		return add(base, exponent), nil
+	case "%":
+		if isLiteral(dr, 0) {
+			return dl, nil
+		}
+	}
+	return nil, &DiffError{e, fmt.Sprintf("cannot differentiate operator %#v", e.Op)}
+}
+
+// The derivatives of the built-in functions, given the call e, its arguments
+// and the derivatives of its arguments, which are not all zero.
+var derivatives = map[string]func(e Expr, args, dargs []Expr) (Expr, error){
+	"sin": chain(func(e, u, du Expr) Expr {
+		return multiply(".*", call("cos", u), du)
+	}),
+	"cos": chain(func(e, u, du Expr) Expr {
+		return negate(multiply(".*", call("sin", u), du))
+	}),
+	"exp": chain(func(e, u, du Expr) Expr {
+		return multiply(".*", e, du)
+	}),
+	"log": chain(func(e, u, du Expr) Expr {
+		return divide("./", du, u)
+	}),
+	"sqrt": chain(func(e, u, du Expr) Expr {
+		return divide("./", du, multiply("*", literal(2), e))
+	}),
+	"abs": chain(func(e, u, du Expr) Expr {
+		return multiply(".*", divide("./", u, e), du)
+	}),
+	"inv": chain(func(e, u, du Expr) Expr {
+		return negate(multiply("*", multiply("*", e, du), e))
+	}),
+	"det": chain(func(e, u, du Expr) Expr {
+		return multiply("*", e, call("trace", multiply("*", call("inv", u), du)))
+	}),
+	"norm": chain(func(e, u, du Expr) Expr {
+		return divide("/", call("trace", multiply("*", &Unary{Op: "'", Elem: u}, du)), e)
+	}),
+	"trace": linear("trace"),
+	"sum":   linear("sum"),
+	"diag":  linear("diag"),
+	"size": func(e Expr, args, dargs []Expr) (Expr, error) {
+		return literal(0), nil
+	},
+	"where": func(e Expr, args, dargs []Expr) (Expr, error) {
+		if len(args) != 3 {
+			return nil, &ArgumentError{e, fmt.Sprintf("expecting 3 arguments, got %d", len(args))}
+		}
+		return call("where", args[0], dargs[1], dargs[2]), nil
+	},
+}
+
+// Returns whether name is a built-in function, which may have no derivative,
+// such as max, eye or qr.
+func isBuiltin(name string) bool {
+	return builtins[name] != nil || multiBuiltins[name] != nil
+}
+
+// Differentiates a function of one argument by the chain rule, given the
+// call e, its argument u and the derivative du.
+func chain(rule func(e, u, du Expr) Expr) func(e Expr, args, dargs []Expr) (Expr, error) {
+	return func(e Expr, args, dargs []Expr) (Expr, error) {
+		if len(args) != 1 {
+			return nil, &ArgumentError{e, fmt.Sprintf("expecting 1 arguments, got %d", len(args))}
+		}
+		return rule(e, args[0], dargs[0]), nil
+	}
+}
+
+// Differentiates a linear function of one argument, whose derivative is the
+// same function of the derivative of its argument.
+func linear(name string) func(e Expr, args, dargs []Expr) (Expr, error) {
+	return chain(func(e, u, du Expr) Expr {
+		return call(name, du)
+	})
+}
+
+// Returns the literal v, written as a negated Num if v is negative so that
+// it prints as it would be parsed.
+func literal(v float64) Expr {
+	if v < 0 {
+		return &Unary{Op: "-", Elem: literal(-v)}
+	}
+	return &Num{Text: strconv.FormatFloat(v, 'g', -1, 64), Value: v}
+}
+
+// Returns the value of e, if it is a literal or a negated literal.
+func literalValue(e Expr) (float64, bool) {
+	switch e := e.(type) {
+	case *Num:
+		return e.Value, true
+	case *Unary:
+		if v, ok := literalValue(e.Elem); ok && e.Op == "-" {
+			return -v, true
+		}
+	}
+	return 0, false
+}
+
+// Returns whether e is the literal v.
+func isLiteral(e Expr, v float64) bool {
+	x, ok := literalValue(e)
+	return ok && x == v
+}
+
+// Returns "name(args...)", with several arguments separated by commas.
+func call(name string, args ...Expr) Expr {
+	operand := args[0]
+	for _, arg := range args[1:] {
+		operand = &Binary{Op: ",", Left: operand, Right: arg}
+	}
+	return &Apply{Operator: &Var{Name: name}, Operand: operand}
+}
+
+func negate(a Expr) Expr {
+	if x, ok := literalValue(a); ok {
+		return literal(-x)
+	} else if u, ok := a.(*Unary); ok && u.Op == "-" {
+		return u.Elem
+	}
+	return &Unary{Op: "-", Elem: a}
+}
+
+func add(a, b Expr) Expr {
+	x, xok := literalValue(a)
+	y, yok := literalValue(b)
+	switch {
+	case xok && yok:
+		return literal(x + y)
+	case xok && x == 0:
+		return b
+	case yok && y == 0:
+		return a
+	}
+	return &Binary{Op: "+", Left: a, Right: b}
+}
+
+func subtract(a, b Expr) Expr {
+	x, xok := literalValue(a)
+	y, yok := literalValue(b)
+	switch {
+	case xok && yok:
+		return literal(x - y)
+	case xok && x == 0:
+		return negate(b)
+	case yok && y == 0:
+		return a
+	}
+	return &Binary{Op: "-", Left: a, Right: b}
+}
+
+// Multiplies with op, which is either "*" or ".*".
+func multiply(op string, a, b Expr) Expr {
+	x, xok := literalValue(a)
+	y, yok := literalValue(b)
+	switch {
+	case xok && yok:
+		return literal(x * y)
+	case xok && x == 0, yok && y == 0:
+		return literal(0)
+	case xok && x == 1:
+		return b
+	case yok && y == 1:
+		return a
+	case xok && x == -1:
+		return negate(b)
+	case yok && y == -1:
+		return negate(a)
+	}
+	return &Binary{Op: op, Left: a, Right: b}
+}
+
+// Divides with op, which is "/", "./" or "\", in which case b is divided by
+// a instead.
+func divide(op string, a, b Expr) Expr {
+	num, den := a, b
+	if op == "\\" {
+		num, den = b, a
+	}
+	if isLiteral(num, 0) {
+		return literal(0)
+	} else if isLiteral(den, 1) {
+		return num
+	}
+	return &Binary{Op: op, Left: a, Right: b}
+}
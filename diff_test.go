@@ -0,0 +1,234 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"math"
+	"testing"
+)
+
+// Evaluates the expression e at the given scalar values.
+func evalAt(t *testing.T, e Expr, values map[string]float64) float64 {
+	prog, err := NewEnv().CompileEquations(&Equation{Left: &Var{Name: "result"}, Right: e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := 0.0
+	args := []interface{}{&result}
+	for _, name := range prog.Vars()[1:] {
+		value := values[name]
+		args = append(args, &value)
+	}
+	if err := prog.Run(args...); err != nil {
+		t.Fatalf("%s, while evaluating %s", err, e)
+	}
+	return result
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		Source string
+		Rep    string
+	}{
+		{"3*x + 2", "3"},
+		{"x^2", "(2 * x)"},
+		{"x.^3", "(3 .* (x .^ 2))"},
+		{"x^n", "(n * (x ^ (n - 1)))"},
+		{"2^x", "((2 ^ x) * (log 2))"},
+		{"x * y * x", "((y * x) + (x * y))"},
+		{"A * x + b", "A"},
+		{"sin(x^2)", "((cos (x ^ 2)) .* (2 * x))"},
+		{"exp(-x)", "(- (exp (- x)))"},
+		{"1 / x", "((- (1 / x)) / x)"},
+		{"A \\ x", "(A \\ 1)"},
+		{"inv(A x)", "(- (((inv (A x)) * A) * (inv (A x))))"},
+		{"det(x A)", "((det (x A)) * (trace ((inv (x A)) * A)))"},
+		{"x > 0 ? x : 0", "((x > 0) ? 1 : 0)"},
+		{"where(x > 0, x, -x)", "(where (((x > 0) , 1) , (- 1)))"},
+		{"-(-x) + y", "1"},
+		{"sum(y)", "0"},
+		{"size(x) + x", "1"},
+		{"size(x * A)", "0"},
+		{"x * eye(2) + max(y)", "(eye 2)"},
+	}
+
+	for _, test := range tests {
+		e, err := PEMDAS.ParseExpr(test.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := Diff(e, "x")
+		if err != nil {
+			t.Errorf("%s, while differentiating %s", err, test.Source)
+			continue
+		}
+		if d.String() != test.Rep {
+			t.Errorf("differentiating %s\ngot       %s\nexpecting %s", test.Source, d, test.Rep)
+		}
+	}
+}
+
+func TestDiffNumerically(t *testing.T) {
+	sources := []string{
+		"x^3 - 2*x + 1",
+		"sin(x) * cos(2*x)",
+		"exp(x) / (1 + x^2)",
+		"log(x) .* sqrt(x)",
+		"abs(y - x) + x ./ y",
+		"inv(x y) * det(x + y)",
+		"y \\ x^2",
+		"x^y + y^x",
+		"norm(x * y)' * trace(x)",
+		"x > 1 ? x^2 : -x",
+	}
+	values := map[string]float64{"x": 0.7, "y": 1.3}
+
+	for _, source := range sources {
+		e, err := PEMDAS.ParseExpr(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := Diff(e, "x")
+		if err != nil {
+			t.Errorf("%s, while differentiating %s", err, source)
+			continue
+		}
+
+		const h = 1e-6
+		got := evalAt(t, d, values)
+		values["x"] += h
+		above := evalAt(t, e, values)
+		values["x"] -= 2 * h
+		below := evalAt(t, e, values)
+		values["x"] += h
+
+		if want := (above - below) / (2 * h); math.Abs(got-want) > 1e-5 {
+			t.Errorf("differentiating %s gave %s\ngot       %v\nexpecting %v", source, d, got, want)
+		}
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	for _, source := range []string{
+		"max(x)", "y % x", "{x}", "eye(x)", "zeros(x, 2)", "ones(2 x)",
+		"qr(x)", "lu(x')", "eig(x + y)", "svd(x)",
+	} {
+		e, err := PEMDAS.ParseExpr(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Diff(e, "x"); err == nil {
+			t.Errorf("differentiating %s: expected an error", source)
+		} else if _, ok := err.(*DiffError); !ok {
+			t.Errorf("differentiating %s: got %v, expecting a *DiffError", source, err)
+		}
+	}
+}
+
+func TestDiffMatrix(t *testing.T) {
+	A := [][]float64{{2, 1}, {1, 3}}
+	B := [][]float64{{0, 1}, {-1, 2}}
+	b := []float64{1, 2}
+
+	e, err := PEMDAS.ParseExpr("inv(A + t B) * b + (A * t^2)' * b + det(A t) b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Diff(e, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(e Expr, t0 float64) []float64 {
+		prog, err := NewEnv().CompileEquations(&Equation{Left: &Var{Name: "y"}, Right: e})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var y []float64
+		if err := prog.RunMap(map[string]interface{}{
+			"y": &y, "A": &A, "B": &B, "b": &b, "t": &t0,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return y
+	}
+
+	const h = 1e-6
+	got, above, below := at(d, 0.5), at(e, 0.5+h), at(e, 0.5-h)
+	for i := range got {
+		if want := (above[i] - below[i]) / (2 * h); math.Abs(got[i]-want) > 1e-5 {
+			t.Errorf("got %v, expecting %v in row %d of %s", got[i], want, i, d)
+		}
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	A := [][]float64{{2, 1}, {1, 3}}
+	x := []float64{1, 2}
+	dx := []float64{0.3, -0.2}
+
+	tests := []struct {
+		Source string
+		Rep    string
+	}{
+		{"x' * x", "(((' dx) * x) + ((' x) * dx))"},
+		{"x' * A * x", "((((' dx) * A) * x) + (((' x) * A) * dx))"},
+		{"A * x + x", "((A * dx) + dx)"},
+		{"norm(A * x)", "((trace ((' (A * x)) * (A * dx))) / (norm (A * x)))"},
+		{"sum(exp(x) .* x)", "(sum ((((exp x) .* dx) .* x) + ((exp x) .* dx)))"},
+		{"inv(A + x * x') * x", ""},
+		{"det(A + x * x') * x", ""},
+	}
+
+	at := func(e Expr, x []float64, dx []float64) []float64 {
+		prog, err := NewEnv().CompileEquations(&Equation{Left: &Var{Name: "y"}, Right: e})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var y []float64
+		values := map[string]interface{}{"y": &y, "A": &A, "x": &x, "dx": &dx}
+		args := map[string]interface{}{}
+		for _, name := range prog.Vars() {
+			args[name] = values[name]
+		}
+		if err := prog.RunMap(args); err != nil {
+			t.Fatalf("%s, while evaluating %s", err, e)
+		}
+		return y
+	}
+
+	const h = 1e-6
+	above, below := []float64{}, []float64{}
+	for i := range x {
+		above = append(above, x[i]+h*dx[i])
+		below = append(below, x[i]-h*dx[i])
+	}
+
+	for _, test := range tests {
+		e := mustParse(t, test.Source)
+		d, err := Differential(e, "x", &Var{Name: "dx"})
+		if err != nil {
+			t.Errorf("%s, while differentiating %s", err, test.Source)
+			continue
+		}
+		if test.Rep != "" && d.String() != test.Rep {
+			t.Errorf("differentiating %s\ngot       %s\nexpecting %s", test.Source, d, test.Rep)
+		}
+
+		got, hi, lo := at(d, x, dx), at(e, above, dx), at(e, below, dx)
+		for i := range got {
+			if want := (hi[i] - lo[i]) / (2 * h); math.Abs(got[i]-want) > 1e-5 {
+				t.Errorf("differentiating %s gave %s\ngot       %v\nexpecting %v in row %d",
+					test.Source, d, got[i], want, i)
+			}
+		}
+	}
+
+	// Diff takes x to be a scalar, as in the differential along 1
+	d, err := Diff(mustParse(t, "x' * x"), "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.String(); got != "(x + (' x))" {
+		t.Errorf("got %s, expecting (x + (' x))", got)
+	}
+}
@@ -70,24 +70,76 @@ to evaluate. For example, if we run:
 then err will be nil and tree will be as follows:
 
   tree := &Equation{
-  	Left: &Var{"y"},
+  	Left: &Var{Name: "y"},
   	Right: &Binary{
   		Op: "+",
   		Left: &Binary{
   			Op: "*",
   			Left: &Unary{
   				Op: "-",
-  				Elem: &Var{"a"},
+  				Elem: &Var{Name: "a"},
   			},
-  			Right: &Var{"b"},
+  			Right: &Var{Name: "b"},
   		}
-  		Right: &Var{"c"}
+  		Right: &Var{Name: "c"}
   	}
   }
 
 By iterating over the tree, your DSL can evaluate the mathematical
 expression while maintaining type integrity.
 
+Each node also records the Span of source code it was parsed from, and an
+*Unexpected error gives the Pos of the offending token. Adding the Span
+fields means that nodes written as unkeyed composite literals, such as
+&mast.Var{"x"} or &mast.Binary{"+", a, b}, no longer compile; name the
+fields instead, as in &mast.Var{Name: "x"}.
+
+Rather than switching on every type of Expr, a tool can call Walk, which
+visits each node of a tree (Equation and Cond included) depth-first, or
+WalkVisitor with a Visitor, much like go/ast. Transform rebuilds a tree
+bottom-up, replacing each node with the result of a function:
+
+  mast.Walk(tree, func(e mast.Expr) bool {
+  	if v, ok := e.(*mast.Var); ok {
+  		fmt.Println(v.Name)
+  	}
+  	return true
+  })
+
+Trees can also be differentiated symbolically. Diff(e, "x") returns a new
+Expr for the derivative of e with respect to x, applying the chain rule to
+the built-in functions and keeping matrix products in order, so that a
+gradient can be generated from the same formula it belongs to:
+
+  tree, err := mast.PEMDAS.Parse("y = sin(w * x) + b")
+  dy, err := mast.Diff(tree.Right, "w") // ((cos (w * x)) .* x)
+
+Diff takes x to be a scalar. For a vector or matrix, Differential(e, "x", dx)
+gives the change in e as x changes by dx, such as "dx' * A * x + x' * A * dx"
+for "x' * A * x".
+
+Simplify removes redundancies such as "a''", "--b", "x * 1" or "0 + y",
+folds integer constants and collects like terms. Its result is canonical,
+with the terms of sums and other commutative operations in a fixed order, so
+Equal reports whether two formulas are the same up to these rules:
+
+  mast.Equal(mast.Simplify(a), mast.Simplify(b))
+
+The String method of an Expr writes every operation in parentheses, as in
+"((- a) ^ 2)". Format instead writes the source code a Parser would read,
+with only the parentheses its precedence and associativity require, so that
+parsing the result gives back the same tree:
+
+  mast.Format(mast.PEMDAS, tree) // y = -a ^ 2 + sin(x)'
+
+Much as a regular expression matches text, a pattern matches trees. Patterns
+are parsed with ParsePattern, and may use metavariables such as "?a", which
+Match binds to whatever they stand for. A Rule parsed with ParseRule pairs a
+pattern with its replacement, and Rewrite applies rules until none match:
+
+  rule, err := mast.PEMDAS.ParseRule("?a * (?b + ?c) -> ?a * ?b + ?a * ?c")
+  tree, err := mast.Rewrite(tree, []mast.Rule{rule})
+
 Evaluator
 
 Mast includes a toy evaluator that handles matrices as [][]float64.
@@ -98,14 +150,124 @@ function, passing pointers to the respective arguments.
   // assigned left to right based on first usage.
   func Eval(code string, args ...interface{}) error {
 
+The variable being assigned always comes first. If it also appears on the
+right-hand side, as in "x = x + A*d", its current value is read before the
+result overwrites it, which suits iterative updates.
+
 Think %-arguments to fmt.Printf. To make setting up variables easier,
-arguments can be specified in three ways:
+arguments can be specified in four ways:
 
+  - a Matrix, which is read without copying, and written as a new copy;
   - a [][]float64 for an n x m matrix;
   - a []float64 for an 1 x n column vector; or
   - a float64, for a 1 x 1 scalar.
 
-All other types panic.
+All other types are rejected with an UnsupportedTypeError.
+
+Expressions are evaluated with matrix semantics: "+" and "-" add and subtract,
+"*" multiplies, "'" transposes, "A\b" solves the linear system A * x = b,
+"A/B" divides on the right, and "A^n" raises a square matrix to an integer
+power. Multiplying or dividing by a 1 x 1 value scales every element.
+
+Like "+" and "-", the elementwise operators ".*", "./" and ".^" combine
+corresponding elements, as in "y = w .* x + b". The operands of all five are
+broadcast: a scalar, row vector or column vector is repeated to match the size
+of the other operand, as in "A + 1". Any other mismatch in size is reported
+as a DimensionError.
+
+The comparisons "==", "!=", "<", "<=", ">" and ">=" and the logical
+operators "&&", "||" and "!" also work elementwise, giving a mask of 1 where
+they hold and 0 elsewhere. A conditional such as "y = x > 0 ? x : 0" chooses
+each element from one branch or the other by the mask, broadcasting all
+three, as does where(x > 0, x, 0). Both branches are always evaluated,
+except in a Chooser such as Int64 or Mod, whose scalar tests pick one.
+
+Applying one of the built-in functions calls it, as in inv(A); applying any
+other name multiplies, as in A x. The built-in functions are:
+
+  inv det trace sin cos exp log sqrt abs norm sum max min diag eye zeros ones
+  size where
+
+Used as a single value, size(A) gives a 1-by-2 row of the number of rows and
+columns of A.
+
+Some functions return several values, which are assigned to several
+variables at once, as in "q, r = qr(A)". These are qr, lu (giving L, U and P
+such that P * A = L * U), eig (for symmetric matrices, giving V and D), svd
+(giving U, S and V), and size. Several expressions may also be assigned
+together, as in "a, b = b, a + b". Register your own with RegisterMultiFunc.
+
+Longer formulas can be split into several equations, separated by ";" or
+new lines. Variables assigned by earlier equations are temporaries, and only
+those assigned by the last equation are passed in as arguments:
+
+  mast.Eval("t = A' * b; x = inv(A' * A) * t; r = b - A * x", &r, &A, &b)
+
+Binding variables by position is fragile when formulas change, so EvalMap
+binds them by name instead, reporting any that are missing or unused:
+
+  err := mast.EvalMap("y = A * x + b", map[string]interface{}{
+  	"y": &y, "A": &A, "x": &x, "b": &b,
+  })
+
+When evaluating the same formula many times, use Compile to parse it once.
+The resulting *Program is safe for concurrent use, much like a compiled
+regexp.Regexp.
+
+  prog := mast.MustCompile("y = A * x + b")
+  fmt.Println(prog.Vars()) // [y A x b]
+  for ... {
+  	prog.MustRun(&y, &A, &x, &b)
+  }
+
+Internally, every value is a Matrix: a grid of numbers stored in a single
+contiguous slice with strides, so that transposing is a view rather than a
+copy. Functions registered with RegisterFunc receive and return them, and
+can use NewMatrix, At, Set and Dims to build and inspect them.
+
+To add functions of your own, create an Env with NewEnv() and call
+RegisterFunc. Calls with several arguments, as in clip(x, 0, 1), pass each
+argument separately.
+
+  env := mast.NewEnv()
+  env.RegisterFunc("sigmoid", func(args ...mast.Matrix) (mast.Matrix, error) {
+  	...
+  })
+  env.Eval("y = sigmoid(W * x + b)", &y, &W, &x, &b)
+
+Values, literals and operators are given meaning by an Algebra, so other
+numeric types can be plugged in without changing the evaluator. The default is
+Float64, which computes with a Matrix. To use another, implement the
+Algebra interface and create an Env with NewAlgebraEnv; its functions are
+registered with RegisterValueFunc, or provided by implementing Library.
+
+The Complex128 algebra evaluates [][]complex128 matrices instead, reading
+*complex128, *[]complex128 and *[][]complex128 arguments. Here "i" and
+"j" are the imaginary unit, as in "z = 3 + 4i", unless assigned to. The
+operator "'" takes the conjugate transpose, while ".'" transposes without
+conjugating:
+
+  env := mast.NewAlgebraEnv(mast.Complex128{})
+  env.Eval("y = A' * x + 2i", &y, &A, &x)
+
+For exact or high-precision results, the BigRat algebra evaluates
+[][]*big.Rat matrices, reading literals such as 0.1 exactly, and
+BigFloat{Prec: 200} evaluates [][]*big.Float matrices with 200-bit
+mantissas. A formula parsed once can be compiled for several algebras with
+CompileEquations, so that it may be re-run precisely:
+
+  tree, err := mast.PEMDAS.Parse("y = x / 10 * 3")
+  exact, err := mast.NewAlgebraEnv(mast.BigRat{}).CompileEquations(tree)
+  exact.MustRun(y, x) // with y and x of type *big.Rat
+
+The Int64 algebra evaluates int64 scalars, reading *int64 and *int
+arguments, and reports an ArithmeticError rather than overflowing. Mod{P: p}
+computes modulo p instead, where "/" multiplies by the modular inverse and
+"x^-1" is the inverse of x. Both support "%", the remainder, which PEMDAS
+parses alongside "*":
+
+  env := mast.NewAlgebraEnv(mast.Mod{P: 1000000007})
+  env.Eval("y = (a * b^-1 + c) % 256", &y, &a, &b, &c)
 
 Evaluator Example
 
@@ -122,7 +284,7 @@ a matrix, and adding a vector). First, we set up the variables to compute:
   b := 5.0
   // same as := [][]float64{[]float64{5.0}}
 
-  y := 0
+  y := 0.0
 
 Once those are set up, the computation is fairly easy.
 
@@ -0,0 +1,132 @@
+package mast
+
+import (
+	"fmt"
+)
+
+// Describes where in the source code e appears, for use in error messages.
+func where(e Expr) string {
+	if e == nil {
+		return ""
+	}
+	result := fmt.Sprintf(", in %s", e)
+	if start := spanOf(e).Start; start.Line > 0 {
+		result += fmt.Sprintf(" at %s", start)
+	}
+	return result
+}
+
+// A DimensionError indicates that the operands of Expr have sizes that do
+// not fit together, such as multiplying a 2-by-3 matrix by a 2-by-3 matrix.
+type DimensionError struct {
+	Expr   Expr
+	Reason string
+}
+
+// Represent this DimensionError as a string.
+func (d DimensionError) Error() string {
+	return d.Reason + where(d.Expr)
+}
+
+// An UndefinedVariableError indicates that Expr refers to a variable that was
+// never given a value.
+type UndefinedVariableError struct {
+	Expr Expr
+	Name string
+}
+
+// Represent this UndefinedVariableError as a string.
+func (u UndefinedVariableError) Error() string {
+	return fmt.Sprintf("undefined variable %#v", u.Name) + where(u.Expr)
+}
+
+// An UnusedVariableError indicates that a variable was given a value, but
+// does not appear in the equation being evaluated.
+type UnusedVariableError struct {
+	Name string
+}
+
+// Represent this UnusedVariableError as a string.
+func (u UnusedVariableError) Error() string {
+	return fmt.Sprintf("unused variable %#v", u.Name)
+}
+
+// An UnsupportedTypeError indicates that the Go value bound to Expr is not
+// of a type the evaluator understands.
+type UnsupportedTypeError struct {
+	Expr  Expr
+	Value interface{}
+}
+
+// Represent this UnsupportedTypeError as a string.
+func (u UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported type %T", u.Value) + where(u.Expr)
+}
+
+// An UnknownOperatorError indicates that Expr uses an operator that the
+// evaluator does not know how to compute.
+type UnknownOperatorError struct {
+	Expr Expr
+	Op   string
+}
+
+// Represent this UnknownOperatorError as a string.
+func (u UnknownOperatorError) Error() string {
+	return fmt.Sprintf("unknown operator %#v", u.Op) + where(u.Expr)
+}
+
+// An ArithmeticError indicates that Expr has no sensible value, such as when
+// solving a singular system of equations.
+type ArithmeticError struct {
+	Expr   Expr
+	Reason string
+}
+
+// Represent this ArithmeticError as a string.
+func (a ArithmeticError) Error() string {
+	return a.Reason + where(a.Expr)
+}
+
+// An ArgumentError indicates that a function in Expr was called with the
+// wrong number of arguments.
+type ArgumentError struct {
+	Expr   Expr
+	Reason string
+}
+
+// Represent this ArgumentError as a string.
+func (a ArgumentError) Error() string {
+	return a.Reason + where(a.Expr)
+}
+
+// Attributes err to e, unless it has already been attributed to a more
+// specific sub-expression.
+func at(err error, e Expr) error {
+	switch err := err.(type) {
+	case *DimensionError:
+		if err.Expr == nil {
+			err.Expr = e
+		}
+	case *UndefinedVariableError:
+		if err.Expr == nil {
+			err.Expr = e
+		}
+	case *UnsupportedTypeError:
+		if err.Expr == nil {
+			err.Expr = e
+		}
+	case *UnknownOperatorError:
+		if err.Expr == nil {
+			err.Expr = e
+		}
+	case *ArithmeticError:
+		if err.Expr == nil {
+			err.Expr = e
+		}
+	case *ArgumentError:
+		if err.Expr == nil {
+			err.Expr = e
+		}
+	}
+	return err
+}
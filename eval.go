@@ -4,207 +4,325 @@ import (
 	"fmt"
 )
 
-func addVars(e Expr, vars *[]string) {
-	switch e := e.(type) {
-	case *Var:
-		for _, v := range *vars {
-			if v == e.Name {
-				return
-			}
-		}
-		*vars = append(*vars, e.Name)
-	case *Apply:
-		addVars(e.Operator, vars)
-		addVars(e.Operand, vars)
-	case *Unary:
-		addVars(e.Elem, vars)
-	case *Binary:
-		addVars(e.Left, vars)
-		addVars(e.Right, vars)
-	case *Equation:
-		addVars(e.Left, vars)
-		addVars(e.Right, vars)
-	default:
-		panic(fmt.Sprintf("strange Expr: %#v", e))
+// An Env evaluates expressions using an Algebra, calling both its built-in
+// functions and any registered with RegisterFunc.
+type Env struct {
+	algebra Algebra
+	funcs   map[string]ValueFunc
+	multis  map[string]MultiValueFunc
+}
+
+// Creates a new Env that knows only the built-in functions, and evaluates
+// using the Float64 algebra.
+func NewEnv() *Env {
+	return NewAlgebraEnv(Float64{})
+}
+
+// Creates a new Env that evaluates using the given Algebra. If it is also a
+// Library, its functions are registered in the new Env.
+func NewAlgebraEnv(a Algebra) *Env {
+	env := &Env{a, map[string]ValueFunc{}, map[string]MultiValueFunc{}}
+	if lib, ok := a.(Library); ok {
+		env.funcs, env.multis = lib.Funcs(), lib.MultiFuncs()
 	}
+	return env.clone()
 }
 
-func readMat(x interface{}) [][]float64 {
-	switch x := x.(type) {
-	case *float64:
-		return [][]float64{[]float64{*x}}
-	case *[]float64:
-		result := make([][]float64, len(*x))
-		for i, v := range *x {
-			result[i] = []float64{v}
-		}
-		return result
-	case *[][]float64:
-		return *x
-	default:
-		panic(fmt.Errorf("unsupported type %#x", x))
+// Returns a copy of this Env, which can be changed independently.
+func (env *Env) clone() *Env {
+	result := &Env{env.algebra, map[string]ValueFunc{}, map[string]MultiValueFunc{}}
+	for name, fn := range env.funcs {
+		result.funcs[name] = fn
 	}
+	for name, fn := range env.multis {
+		result.multis[name] = fn
+	}
+	return result
 }
 
-func writeMat(x interface{}, result [][]float64) {
-	switch x := x.(type) {
-	case *float64:
-		if len(result) == 1 && len(result[0]) == 1 {
-			*x = result[0][0]
-		} else {
-			panic("attempt to assign non-scalar value to scalar")
-		}
-	case *[]float64:
-		if *x == nil {
-			*x = make([]float64, len(result))
-		} else if len(result) != len(*x) {
-			panic("attempt to assign vectors of differing size")
-		}
+// The Env used by Eval and MustEval.
+var defaultEnv *Env
 
-		for _, row := range result {
-			if len(row) != 1 {
-				panic("attempt to assign non-vector value to vector")
-			}
-		}
+func init() {
+	// set up after the builtins, which NewEnv only reaches through Library
+	defaultEnv = NewEnv()
+}
 
-		for i, row := range result {
-			(*x)[i] = row[0]
-		}
-	case *[][]float64:
-		*x = result // FIXME
-	default:
-		panic(fmt.Errorf("unsupported type %#x", x))
-	}
+// Registers fn under the given name, so that "name(x)" calls fn(x) and
+// "name(a, b, c)" calls fn(a, b, c). This replaces any existing function of
+// the same name, including built-in ones. Calling fn with values that are not
+// a Matrix gives an *UnsupportedTypeError, so use RegisterValueFunc with
+// other algebras.
+func (env *Env) RegisterFunc(name string, fn Func) {
+	env.funcs[name] = fn.value()
 }
 
-func addMats(a, b [][]float64) [][]float64 {
-	// TODO: error handling
+// Registers fn under the given name, as in RegisterFunc, but for functions
+// returning several values, as in "q, r = qr(A)". A name may have both a
+// Func and a MultiFunc; the former is used when only one value is expected.
+func (env *Env) RegisterMultiFunc(name string, fn MultiFunc) {
+	env.multis[name] = fn.value()
+}
 
-	result := make([][]float64, len(a))
-	for i := range a {
-		result[i] = make([]float64, len(a[0]))
-		for j := range a[i] {
-			result[i][j] = a[i][j] + b[i][j]
-		}
+// Registers fn under the given name, as in RegisterFunc, but receiving and
+// returning the values of this Env's Algebra.
+func (env *Env) RegisterValueFunc(name string, fn ValueFunc) {
+	env.funcs[name] = fn
+}
+
+// Registers fn under the given name, as in RegisterMultiFunc, but receiving
+// and returning the values of this Env's Algebra.
+func (env *Env) RegisterMultiValueFunc(name string, fn MultiValueFunc) {
+	env.multis[name] = fn
+}
+
+// Returns the function named by e, if there is one.
+func (env *Env) lookup(e Expr) (ValueFunc, bool) {
+	if v, ok := e.(*Var); ok {
+		fn, ok := env.funcs[v.Name]
+		return fn, ok
 	}
-	return result
+	return nil, false
 }
 
-func dim(x [][]float64) (rows int, cols int) {
-	if rows = len(x); rows == 0 {
-		return
+// Returns the function returning several values named by e, if there is one.
+func (env *Env) lookupMulti(e Expr) (MultiValueFunc, bool) {
+	if v, ok := e.(*Var); ok {
+		fn, ok := env.multis[v.Name]
+		return fn, ok
 	}
-	cols = len(x[0])
-	for i, r := range x[1:] {
-		if cols != len(r) {
-			panic(fmt.Sprintf(
-				"array size mismatch: [0] was an %d-slice, [%d] was an %d-slice",
-				cols, i+1, len(r)))
-		}
+	return nil, false
+}
+
+// Returns the constant of this Env's Algebra with the given name, if any.
+func (env *Env) constant(name string) (Value, bool) {
+	if c, ok := env.algebra.(Constants); ok {
+		return c.Constant(name)
 	}
-	return
+	return nil, false
 }
 
-func transposeMat(a [][]float64) [][]float64 {
-	n, m := dim(a)
-	result := make([][]float64, m)
+// Returns whether e names a function of either kind.
+func (env *Env) isFunc(e Expr) bool {
+	_, ok := env.lookup(e)
+	_, multi := env.lookupMulti(e)
+	return ok || multi
+}
 
-	for i := range result {
-		result[i] = make([]float64, n)
+// Splits the operand of a function call into its arguments, so that the
+// Binary chain ((a , b) , c) becomes [a, b, c].
+func arguments(e Expr) []Expr {
+	if b, ok := e.(*Binary); ok && b.Op == "," {
+		return append(arguments(b.Left), b.Right)
 	}
+	return []Expr{e}
+}
 
-	for i, row := range a {
-		for j := range row {
-			result[j][i] = a[i][j]
+// Collects the first occurrence of each variable in e, skipping the names
+// of functions being called.
+func (env *Env) addVars(e Expr, vars *[]*Var) {
+	Walk(e, func(e Expr) bool {
+		switch e := e.(type) {
+		case *Var:
+			for _, v := range *vars {
+				if v.Name == e.Name {
+					return false
+				}
+			}
+			*vars = append(*vars, e)
+		case *Apply:
+			if env.isFunc(e.Operator) {
+				env.addVars(e.Operand, vars)
+				return false
+			}
 		}
-	}
+		return true
+	})
+}
 
-	return result
+// Evaluates each argument of a function call.
+func (env *Env) evalArgs(e Expr, vars map[string]Value) ([]Value, error) {
+	args := []Value{}
+	for _, arg := range arguments(e) {
+		x, err := env.eval(arg, vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+	}
+	return args, nil
 }
 
-func multMats(a, b [][]float64) [][]float64 {
-	na, ma := dim(a)
-	nb, mb := dim(b)
+// Evaluates e into n values. When n is more than one, e must either call a
+// MultiFunc, or list n expressions separated by commas.
+func (env *Env) evalTuple(e Expr, n int, vars map[string]Value) ([]Value, error) {
+	if n == 1 {
+		result, err := env.eval(e, vars)
+		return []Value{result}, err
+	}
+
+	if app, ok := e.(*Apply); ok {
+		if fn, ok := env.lookupMulti(app.Operator); ok {
+			args, err := env.evalArgs(app.Operand, vars)
+			if err != nil {
+				return nil, err
+			}
+
+			results, err := fn(args...)
+			if err != nil {
+				return nil, at(err, e)
+			} else if len(results) != n {
+				return nil, &ArgumentError{e, fmt.Sprintf(
+					"expecting %d values, got %d", n, len(results))}
+			}
 
-	if ma != nb {
-		panic(fmt.Sprintf("cannot multiply %d-by-%d and %d-by-%d matrices", na, ma, nb, mb))
+			return results, nil
+		}
 	}
 
-	result := make([][]float64, len(a))
-	for i := range a {
-		result[i] = make([]float64, len(b[0]))
-		for j := range b[0] {
-			for k := range a[0] {
-				result[i][j] += a[i][k] * b[k][j]
+	if elems := arguments(e); len(elems) == n {
+		values := []Value{}
+		for _, elem := range elems {
+			value, err := env.eval(elem, vars)
+			if err != nil {
+				return nil, err
 			}
+			values = append(values, value)
 		}
+		return values, nil
 	}
-	return result
+
+	return nil, &ArgumentError{e, fmt.Sprintf("expecting %d values", n)}
 }
 
-func eval(e Expr, vars map[string][][]float64) [][]float64 {
+func (env *Env) eval(e Expr, vars map[string]Value) (Value, error) {
 	switch e := e.(type) {
 	case *Var:
 		val, ok := vars[e.Name]
 		if !ok {
-			panic(fmt.Sprintf("undefined variable %#v\n", e.Name))
+			if val, ok := env.constant(e.Name); ok {
+				return val, nil
+			}
+			return nil, &UndefinedVariableError{e, e.Name}
+		}
+		return val, nil
+
+	case *Num:
+		result, err := env.algebra.Literal(e)
+		return result, at(err, e)
+
+	case *Apply:
+		if fn, ok := env.lookup(e.Operator); ok {
+			args, err := env.evalArgs(e.Operand, vars)
+			if err != nil {
+				return nil, err
+			}
+
+			result, err := fn(args...)
+			return result, at(err, e)
+		} else if _, ok := env.lookupMulti(e.Operator); ok {
+			return nil, &ArgumentError{e, fmt.Sprintf(
+				"%s returns several values, but only one is expected", e.Operator)}
 		}
-		return val
 
-	case *Apply: // treat all application as multiplication
-		return multMats(eval(e.Operator, vars), eval(e.Operand, vars))
+		// treat all other application as multiplication
+		a, err := env.eval(e.Operator, vars)
+		if err != nil {
+			return nil, err
+		}
+		b, err := env.eval(e.Operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		result, err := env.algebra.Binary("*", a, b)
+		return result, at(err, e)
 
 	case *Unary:
-		switch e.Op {
-		case "'":
-			return transposeMat(eval(e.Elem, vars))
-		default:
-			panic(fmt.Sprintf("unknown unary operation: %s", e.Op))
+		x, err := env.eval(e.Elem, vars)
+		if err != nil {
+			return nil, err
 		}
+		result, err := env.algebra.Unary(e.Op, x)
+		return result, at(err, e)
 
 	case *Binary:
-		switch e.Op {
-		case "+":
-			return addMats(eval(e.Left, vars), eval(e.Right, vars))
-		case "*":
-			return multMats(eval(e.Left, vars), eval(e.Right, vars))
-		default:
-			panic(fmt.Sprintf("unknown binary operation: %s", e.Op))
+		a, err := env.eval(e.Left, vars)
+		if err != nil {
+			return nil, err
+		}
+		b, err := env.eval(e.Right, vars)
+		if err != nil {
+			return nil, err
 		}
+		result, err := env.algebra.Binary(e.Op, a, b)
+		return result, at(err, e)
+
+	case *Cond:
+		sel, ok := env.algebra.(Selector)
+		if !ok {
+			return nil, &UnknownOperatorError{e, "?"}
+		}
+		test, err := env.eval(e.Test, vars)
+		if err != nil {
+			return nil, err
+		}
+		if ch, ok := sel.(Chooser); ok {
+			first, err := ch.Choose(test)
+			if err != nil {
+				return nil, at(err, e)
+			} else if first {
+				return env.eval(e.Then, vars)
+			}
+			return env.eval(e.Else, vars)
+		}
+		values := []Value{test}
+		for _, elem := range []Expr{e.Then, e.Else} {
+			value, err := env.eval(elem, vars)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		result, err := sel.Select(values[0], values[1], values[2])
+		return result, at(err, e)
 
 	default:
-		panic(fmt.Sprintf("strange Expr: %#v", e))
+		return nil, fmt.Errorf("strange Expr: %#v", e)
 	}
 }
 
 // Evaluate the given expression with the given variables. Variables are
-// assigned left to right based on first usage.
+// assigned left to right based on first usage. The variable being assigned
+// always comes first; if it is also used on the right-hand side, as in
+// "x = x + A*d", its current value is read before being overwritten.
 func Eval(code string, args ...interface{}) error {
-	tree, err := PEMDAS.Parse(code)
+	return defaultEnv.Eval(code, args...)
+}
+
+// Evaluate the given expression with the given variables, as in Eval, using
+// the functions registered in this Env.
+func (env *Env) Eval(code string, args ...interface{}) error {
+	prog, err := env.Compile(code)
 	if err != nil {
 		return err
 	}
+	return prog.Run(args...)
+}
 
-	if _, ok := tree.Left.(*Var); !ok {
-		return fmt.Errorf("expression was %#v, but must be of the form \"y = ...\"", tree)
-	}
-
-	vars := []string{}
-	addVars(tree, &vars)
-
-	if len(vars) != len(args) {
-		return fmt.Errorf("got %#v args, hoping for %d (to make %v)",
-			len(args), len(vars), vars)
-	}
+// Evaluate the given expression, binding variables by name rather than by
+// position. The values in vars are pointers, just as the arguments to Eval.
+func EvalMap(code string, vars map[string]interface{}) error {
+	return defaultEnv.EvalMap(code, vars)
+}
 
-	scope := map[string][][]float64{}
-	for i, v := range vars[1:] {
-		scope[v] = readMat(args[i+1])
+// Evaluate the given expression with variables bound by name, as in EvalMap,
+// using the functions registered in this Env.
+func (env *Env) EvalMap(code string, vars map[string]interface{}) error {
+	prog, err := env.Compile(code)
+	if err != nil {
+		return err
 	}
-
-	writeMat(args[0], eval(tree.Right, scope))
-
-	return nil
+	return prog.RunMap(vars)
 }
 
 func MustEval(code string, args ...interface{}) {
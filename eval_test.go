@@ -3,6 +3,8 @@ package mast_test
 import (
 	"fmt"
 	. "github.com/fatlotus/mast"
+	"math"
+	"testing"
 )
 
 func handleError(e error) {
@@ -35,3 +37,147 @@ func ExampleMustEval() {
 	fmt.Printf("y = [%.2f %.2f]^T", y[0], y[1])
 	// Output: y = [24.00 47.00]^T
 }
+
+func TestEvalNum(t *testing.T) {
+	y, x := 0.0, 3.0
+	if err := Eval("y = 0.5 * x + 1e1 + 0x10", &y, &x); err != nil {
+		t.Fatal(err)
+	}
+	if y != 27.5 {
+		t.Errorf("got y = %v, expecting 27.5", y)
+	}
+}
+
+func closeTo(a, b [][]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > 1e-9 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestEvalArithmetic(t *testing.T) {
+	A := [][]float64{{2, 1}, {1, 3}}
+	B := [][]float64{{1, 2}, {3, 4}}
+	b := [][]float64{{3}, {5}}
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Result [][]float64
+	}{
+		{"y = A - B", []interface{}{&A, &B}, [][]float64{{1, -1}, {-2, -1}}},
+		{"y = -A + +B", []interface{}{&A, &B}, [][]float64{{-1, 1}, {2, 1}}},
+		{"y = A \\ b", []interface{}{&A, &b}, [][]float64{{0.8}, {1.4}}},
+		{"y = A * (A \\ B)", []interface{}{&A, &B}, B},
+		{"y = (B / A) * A", []interface{}{&B, &A}, B},
+		{"y = A / 2", []interface{}{&A}, [][]float64{{1, 0.5}, {0.5, 1.5}}},
+		{"y = 2 * A", []interface{}{&A}, [][]float64{{4, 2}, {2, 6}}},
+		{"y = A^3", []interface{}{&A}, [][]float64{{15, 20}, {20, 35}}},
+		{"y = A^-1 * A", []interface{}{&A}, [][]float64{{1, 0}, {0, 1}}},
+		{"y = A^0", []interface{}{&A}, [][]float64{{1, 0}, {0, 1}}},
+		{"y = 2^-1 - 3^2", nil, [][]float64{{-8.5}}},
+		{"y = A .* B", []interface{}{&A, &B}, [][]float64{{2, 2}, {3, 12}}},
+		{"y = B ./ A", []interface{}{&B, &A}, [][]float64{{0.5, 2}, {3, 4.0 / 3}}},
+		{"y = A .^ 2", []interface{}{&A}, [][]float64{{4, 1}, {1, 9}}},
+		{"y = 2 .^ b", []interface{}{&b}, [][]float64{{8}, {32}}},
+		{"y = A .* b", []interface{}{&A, &b}, [][]float64{{6, 3}, {5, 15}}},
+		{"y = b' ./ A", []interface{}{&b, &A}, [][]float64{{1.5, 5}, {3, 5.0 / 3}}},
+		{"y = b .* b'", []interface{}{&b}, [][]float64{{9, 15}, {15, 25}}},
+		{"y = A + 1", []interface{}{&A}, [][]float64{{3, 2}, {2, 4}}},
+		{"y = 1 - A", []interface{}{&A}, [][]float64{{-1, 0}, {0, -2}}},
+		{"y = A - b", []interface{}{&A, &b}, [][]float64{{-1, -2}, {-4, -2}}},
+		{"y = b' + A", []interface{}{&b, &A}, [][]float64{{5, 6}, {4, 8}}},
+		{"y = b + b'", []interface{}{&b}, [][]float64{{6, 8}, {8, 10}}},
+		{"y = A > 1", []interface{}{&A}, [][]float64{{1, 0}, {0, 1}}},
+		{"y = A == B'", []interface{}{&A, &B}, [][]float64{{0, 0}, {0, 0}}},
+		{"y = A <= b", []interface{}{&A, &b}, [][]float64{{1, 1}, {1, 1}}},
+		{"y = A != 1 && B < 4", []interface{}{&A, &B}, [][]float64{{1, 0}, {0, 0}}},
+		{"y = !(A > 1) || B == 4", []interface{}{&A, &B}, [][]float64{{0, 1}, {1, 1}}},
+		{"y = A > 1 ? A : 0", []interface{}{&A}, [][]float64{{2, 0}, {0, 3}}},
+		{"y = A > B ? A : B", []interface{}{&A, &B}, [][]float64{{2, 2}, {3, 4}}},
+		{"y = b > 4 ? b' : -1", []interface{}{&b}, [][]float64{{-1, -1}, {3, 5}}},
+		{"y = where(B > 2, B, 0)", []interface{}{&B}, [][]float64{{0, 0}, {3, 4}}},
+		{"y = 1 < 2 ? 3 : 1 / 0", nil, [][]float64{{3}}},
+	}
+
+	for _, test := range tests {
+		var y [][]float64
+		if err := Eval(test.Source, append([]interface{}{&y}, test.Args...)...); err != nil {
+			t.Errorf("%s, while evaluating %s", err, test.Source)
+			continue
+		}
+		if !closeTo(y, test.Result) {
+			t.Errorf("evaluating %s\ngot       %v\nexpecting %v", test.Source, y, test.Result)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	y := 0.0
+	v := []float64{1, 2}
+	w := []float64{1, 2, 3}
+	A := [][]float64{{1, 2}, {3, 4}}
+	S := [][]float64{{1, 2}, {2, 4}}
+	R := [][]float64{{1, 2}, {3}}
+	s := "string"
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Error  string
+	}{
+		{"y = v + w", []interface{}{&y, &v, &w},
+			"cannot add 2-by-1 and 3-by-1 matrices, in (v + w) at 1:5"},
+		{"y = w' - A", []interface{}{&y, &w, &A},
+			"cannot subtract 1-by-3 and 2-by-2 matrices, in ((' w) - A) at 1:5"},
+		{"y = A * v'", []interface{}{&y, &A, &v},
+			"cannot multiply 2-by-2 and 1-by-2 matrices, in (A * (' v)) at 1:5"},
+		{"y = A .* w", []interface{}{&y, &A, &w},
+			"cannot multiply elementwise 2-by-2 and 3-by-1 matrices, in (A .* w) at 1:5"},
+		{"y = S \\ v", []interface{}{&y, &S, &v},
+			"cannot solve with singular matrix, in (S \\ v) at 1:5"},
+		{"y = A", []interface{}{&y, &A},
+			"attempt to assign non-scalar value to scalar, in y at 1:1"},
+		{"y = R", []interface{}{&y, &R},
+			"array size mismatch: [0] was an 2-slice, [1] was an 1-slice, in R at 1:5"},
+		{"y = 2 * s", []interface{}{&y, &s},
+			"unsupported type *string, in s at 1:9"},
+		{"y = {v}", []interface{}{&y, &v},
+			"unknown operator \"{}\", in ({} v) at 1:5"},
+		{"y = v > 1 ? w : 0", []interface{}{&y, &v, &w},
+			"cannot select from 2-by-1, 3-by-1 and 1-by-1 matrices, in ((v > 1) ? w : 0) at 1:5"},
+		{"y = v < w", []interface{}{&y, &v, &w},
+			"cannot compare 2-by-1 and 3-by-1 matrices, in (v < w) at 1:5"},
+		{"y = A^(1/0)", []interface{}{&y, &A},
+			"cannot raise a matrix to power +Inf, which is too large, in (A ^ (1 / 0)) at 1:5"},
+		{"y = A^(2^53)", []interface{}{&y, &A},
+			"cannot raise a matrix to power 9.007199254740992e+15, which is too large, in (A ^ (2 ^ 53)) at 1:5"},
+		{"y = 2 * 1e400", []interface{}{&y},
+			"cannot represent 1e400 as a float64, in 1e400 at 1:9"},
+		{"y = 0x1ffffffffffffffffff", []interface{}{&y},
+			"cannot represent 0x1ffffffffffffffffff as a float64, in 0x1ffffffffffffffffff at 1:5"},
+	}
+
+	for _, test := range tests {
+		err := Eval(test.Source, test.Args...)
+		if err == nil {
+			t.Errorf("evaluating %s: expected an error", test.Source)
+		} else if err.Error() != test.Error {
+			t.Errorf("evaluating %s\ngot       %s\nexpecting %s", test.Source, err, test.Error)
+		}
+	}
+
+	if _, ok := Eval("y = A * v'", &y, &A, &v).(*DimensionError); !ok {
+		t.Errorf("expected a *DimensionError")
+	}
+}
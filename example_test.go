@@ -3,6 +3,7 @@ package mast_test
 import (
 	"fmt"
 	"github.com/fatlotus/mast"
+	"math"
 )
 
 func Example_parse() {
@@ -26,3 +27,48 @@ func Example_evaluate() {
 	// Output:
 	// res = [[5 11] [11 25]]
 }
+
+func ExampleEnv_RegisterFunc() {
+	env := mast.NewEnv()
+	env.RegisterFunc("clip", func(args ...mast.Matrix) (mast.Matrix, error) {
+		if len(args) != 3 {
+			return mast.Matrix{}, fmt.Errorf("clip takes 3 arguments")
+		}
+		x, lo, hi := args[0], args[1].At(0, 0), args[2].At(0, 0)
+		rows, cols := x.Dims()
+		result := mast.NewMatrix(rows, cols, nil)
+		for i := 0; i < rows; i++ {
+			for j := 0; j < cols; j++ {
+				result.Set(i, j, math.Max(lo, math.Min(hi, x.At(i, j))))
+			}
+		}
+		return result, nil
+	})
+
+	y := []float64{0, 0, 0}
+	x := []float64{-2, 0.5, 7}
+	if err := env.Eval("y = clip(2 * x, 0, 1)", &y, &x); err != nil {
+		panic(err)
+	}
+	fmt.Printf("y = %v\n", y)
+
+	// Output:
+	// y = [0 1 1]
+}
+
+func ExampleCompile() {
+	prog := mast.MustCompile("y = A' * x")
+	fmt.Printf("variables: %v\n", prog.Vars())
+
+	A := [][]float64{[]float64{1, 2}, []float64{3, 4}}
+	y := []float64{0, 0}
+	for _, x := range [][]float64{{1, 0}, {0, 1}} {
+		prog.MustRun(&y, &A, &x)
+		fmt.Printf("y = %v\n", y)
+	}
+
+	// Output:
+	// variables: [y A x]
+	// y = [1 2]
+	// y = [3 4]
+}
@@ -0,0 +1,498 @@
+package mast
+
+import (
+	"fmt"
+)
+
+// A field is the arithmetic on elements of type T, which lets algebras other
+// than Float64 share the matrix operations below. Elements are never modified
+// in place, so they may safely be pointers.
+type field[T any] interface {
+	zero() T
+	one() T
+	add(x, y T) T
+	sub(x, y T) T
+	mul(x, y T) T
+	quo(x, y T) (T, error)
+	neg(x T) T
+	conj(x T) T
+	pow(x, y T) (T, error)
+
+	// Returns whether x is zero.
+	isZero(x T) bool
+
+	// Returns whether |x| > |y|, for choosing pivots.
+	greater(x, y T) bool
+
+	// Returns x as an integer, if it is one.
+	integer(x T) (int, bool)
+
+	// Returns an *ArithmeticError if x is out of the range of the field,
+	// such as a big.Float that has overflowed to infinity.
+	check(x T) error
+}
+
+// A grid is the [][]T Value of an Algebra, seen as a matrix of field
+// elements, so that no conversion is needed to operate on it. As its rows may
+// be shared with other Values, a grid is only modified while it is being
+// built, or after being copied.
+type grid[T any] [][]T
+
+// Returns v as a grid, if it is a [][]T.
+func toGrid[T any](v Value) (grid[T], error) {
+	m, ok := v.([][]T)
+	if !ok {
+		return nil, &UnsupportedTypeError{Value: v}
+	}
+	return grid[T](m), nil
+}
+
+// Returns a rows-by-cols grid of zero values of T, to be filled in.
+func newGrid[T any](rows, cols int) grid[T] {
+	elems := make([]T, rows*cols)
+	g := make(grid[T], rows)
+	for i := range g {
+		g[i] = elems[i*cols : (i+1)*cols : (i+1)*cols]
+	}
+	return g
+}
+
+// Returns the number of rows and columns in g.
+func (g grid[T]) Dims() (rows, cols int) {
+	if len(g) == 0 {
+		return 0, 0
+	}
+	return len(g), len(g[0])
+}
+
+func (g grid[T]) copy() grid[T] {
+	result := newGrid[T](g.Dims())
+	for i, row := range g {
+		copy(result[i], row)
+	}
+	return result
+}
+
+func (g grid[T]) swapRows(i, j int) {
+	g[i], g[j] = g[j], g[i]
+}
+
+func scalarGrid[T any](x T) grid[T] {
+	return grid[T]{{x}}
+}
+
+func (g grid[T]) scalar() (T, bool) {
+	if len(g) == 1 && len(g[0]) == 1 {
+		return g[0][0], true
+	}
+	var zero T
+	return zero, false
+}
+
+func identityGrid[T any](f field[T], n int) grid[T] {
+	g := newGrid[T](n, n)
+	for i := range g {
+		for j := range g[i] {
+			g[i][j] = f.zero()
+		}
+		g[i][i] = f.one()
+	}
+	return g
+}
+
+// Transposes g, taking the complex conjugate of each element if conj is set.
+func transposeGrid[T any](f field[T], g grid[T], conj bool) grid[T] {
+	rows, cols := g.Dims()
+	result := newGrid[T](cols, rows)
+	for i, row := range g {
+		for j, x := range row {
+			if conj {
+				x = f.conj(x)
+			}
+			result[j][i] = x
+		}
+	}
+	return result
+}
+
+// Checks every element of g, as in field.check.
+func checkGrid[T any](f field[T], g grid[T]) error {
+	for _, row := range g {
+		for _, x := range row {
+			if err := f.check(x); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func mapGrid[T any](g grid[T], fn func(x T) T) grid[T] {
+	result := newGrid[T](g.Dims())
+	for i, row := range g {
+		for j, x := range row {
+			result[i][j] = fn(x)
+		}
+	}
+	return result
+}
+
+// Combines corresponding elements of a and b, broadcasting as in
+// broadcastMats.
+func broadcastGrids[T any](a, b grid[T], op string, fn func(x, y T) (T, error)) (grid[T], error) {
+	rows, cols, err := broadcastDims(op, a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// index 0 along any dimension of size 1
+	clamp := func(k, n int) int {
+		if n == 1 {
+			return 0
+		}
+		return k
+	}
+
+	result := newGrid[T](rows, cols)
+	for i := range result {
+		x, y := a[clamp(i, len(a))], b[clamp(i, len(b))]
+		for j := range result[i] {
+			v, err := fn(x[clamp(j, len(x))], y[clamp(j, len(y))])
+			if err != nil {
+				return nil, err
+			}
+			result[i][j] = v
+		}
+	}
+	return result, nil
+}
+
+func multGrids[T any](f field[T], a, b grid[T]) (grid[T], error) {
+	if k, ok := a.scalar(); ok {
+		return mapGrid(b, func(x T) T { return f.mul(k, x) }), nil
+	} else if k, ok := b.scalar(); ok {
+		return mapGrid(a, func(x T) T { return f.mul(x, k) }), nil
+	}
+
+	ar, ac := a.Dims()
+	br, bc := b.Dims()
+	if ac != br {
+		return nil, &DimensionError{Reason: fmt.Sprintf(
+			"cannot multiply %d-by-%d and %d-by-%d matrices", ar, ac, br, bc)}
+	}
+
+	result := newGrid[T](ar, bc)
+	for i := range result {
+		for j := range result[i] {
+			total := f.zero()
+			for k := 0; k < ac; k++ {
+				total = f.add(total, f.mul(a[i][k], b[k][j]))
+			}
+			result[i][j] = total
+		}
+	}
+	return result, nil
+}
+
+// Solves a * x = b for x, as in solveMats.
+func solveGrids[T any](f field[T], a, b grid[T]) (grid[T], error) {
+	if k, ok := a.scalar(); ok {
+		return broadcastGrids(b, scalarGrid(k), "divide", f.quo)
+	}
+
+	n, cols := a.Dims()
+	br, bc := b.Dims()
+	if n != cols {
+		return nil, &DimensionError{Reason: fmt.Sprintf(
+			"cannot solve with non-square %d-by-%d matrix", n, cols)}
+	} else if n != br {
+		return nil, &DimensionError{Reason: fmt.Sprintf(
+			"cannot solve %d-by-%d and %d-by-%d matrices", n, cols, br, bc)}
+	}
+
+	lhs, rhs := a.copy(), b.copy()
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if f.greater(lhs[row][col], lhs[pivot][col]) {
+				pivot = row
+			}
+		}
+		if f.isZero(lhs[pivot][col]) {
+			return nil, &ArithmeticError{Reason: "cannot solve with singular matrix"}
+		}
+		lhs.swapRows(col, pivot)
+		rhs.swapRows(col, pivot)
+
+		for row := col + 1; row < n; row++ {
+			r, err := f.quo(lhs[row][col], lhs[col][col])
+			if err != nil {
+				return nil, err
+			}
+			for k := col; k < n; k++ {
+				lhs[row][k] = f.sub(lhs[row][k], f.mul(r, lhs[col][k]))
+			}
+			for k := 0; k < bc; k++ {
+				rhs[row][k] = f.sub(rhs[row][k], f.mul(r, rhs[col][k]))
+			}
+		}
+	}
+
+	for col := n - 1; col >= 0; col-- {
+		for k := 0; k < bc; k++ {
+			v := rhs[col][k]
+			for j := col + 1; j < n; j++ {
+				v = f.sub(v, f.mul(lhs[col][j], rhs[j][k]))
+			}
+			v, err := f.quo(v, lhs[col][col])
+			if err != nil {
+				return nil, err
+			}
+			rhs[col][k] = v
+		}
+	}
+	return rhs, nil
+}
+
+// Computes a/b, which is the same as (b.' \ a.').'.
+func divGrids[T any](f field[T], a, b grid[T]) (grid[T], error) {
+	if k, ok := b.scalar(); ok {
+		return broadcastGrids(a, scalarGrid(k), "divide", f.quo)
+	}
+	x, err := solveGrids(f, transposeGrid(f, b, false), transposeGrid(f, a, false))
+	if err != nil {
+		return nil, err
+	}
+	return transposeGrid(f, x, false), nil
+}
+
+// Computes a^b, as in powMats.
+func powGrids[T any](f field[T], a, b grid[T]) (grid[T], error) {
+	y, ok := b.scalar()
+	if !ok {
+		return nil, &DimensionError{Reason: "cannot raise to a non-scalar power"}
+	}
+	if x, ok := a.scalar(); ok {
+		v, err := f.pow(x, y)
+		return scalarGrid(v), err
+	}
+
+	if rows, cols := a.Dims(); rows != cols {
+		return nil, &DimensionError{Reason: fmt.Sprintf(
+			"cannot raise non-square %d-by-%d matrix to a power", rows, cols)}
+	}
+	n, ok := f.integer(y)
+	if !ok {
+		return nil, &ArithmeticError{Reason: fmt.Sprintf(
+			"cannot raise a matrix to non-integer power %v", y)}
+	}
+
+	var err error
+	if n < 0 {
+		if a, err = solveGrids(f, a, identityGrid(f, len(a))); err != nil {
+			return nil, err
+		}
+		n = -n
+	}
+
+	// exponentiation by squaring (neither can fail, as a is square), which
+	// stops as soon as the elements leave the range of the field
+	result := identityGrid(f, len(a))
+	for ; n > 0; n /= 2 {
+		if n%2 == 1 {
+			result, _ = multGrids(f, result, a)
+			if err := checkGrid(f, result); err != nil {
+				return nil, err
+			}
+		}
+		if n > 1 {
+			a, _ = multGrids(f, a, a)
+			if err := checkGrid(f, a); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// Raises x to the integer power n by repeated squaring.
+func powElem[T any](f field[T], x T, n int) (T, error) {
+	result, invert := f.one(), n < 0
+	if invert {
+		n = -n
+	}
+	for ; n > 0; n /= 2 {
+		if n%2 == 1 {
+			result = f.mul(result, x)
+			if err := f.check(result); err != nil {
+				return result, err
+			}
+		}
+		if n > 1 {
+			x = f.mul(x, x)
+			if err := f.check(x); err != nil {
+				return result, err
+			}
+		}
+	}
+	if invert {
+		return f.quo(f.one(), result)
+	}
+	return result, nil
+}
+
+// Computes the operators of Float64 for the elements of any field, except
+// that "'" takes the conjugate transpose and ".'" the plain transpose.
+func fieldUnary[T any](f field[T], op string, x Value) (Value, error) {
+	a, err := toGrid[T](x)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "'":
+		return [][]T(transposeGrid(f, a, true)), nil
+	case ".'":
+		return [][]T(transposeGrid(f, a, false)), nil
+	case "-":
+		return [][]T(mapGrid(a, f.neg)), nil
+	case "+":
+		return x, nil
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+}
+
+func fieldBinary[T any](f field[T], op string, x, y Value) (Value, error) {
+	a, err := toGrid[T](x)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toGrid[T](y)
+	if err != nil {
+		return nil, err
+	}
+
+	total := func(fn func(x, y T) T) func(x, y T) (T, error) {
+		return func(x, y T) (T, error) { return fn(x, y), nil }
+	}
+
+	var result grid[T]
+	switch op {
+	case "+":
+		result, err = broadcastGrids(a, b, "add", total(f.add))
+	case "-":
+		result, err = broadcastGrids(a, b, "subtract", total(f.sub))
+	case "*":
+		result, err = multGrids(f, a, b)
+	case "/":
+		result, err = divGrids(f, a, b)
+	case "\\":
+		result, err = solveGrids(f, a, b)
+	case "^":
+		result, err = powGrids(f, a, b)
+	case ".*":
+		result, err = broadcastGrids(a, b, "multiply elementwise", total(f.mul))
+	case "./":
+		result, err = broadcastGrids(a, b, "divide elementwise", f.quo)
+	case ".^":
+		result, err = broadcastGrids(a, b, "raise elementwise", f.pow)
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+	if err != nil {
+		return nil, err
+	} else if err := checkGrid(f, result); err != nil {
+		return nil, err
+	}
+	return [][]T(result), nil
+}
+
+// Turns a function of a single grid into a ValueFunc, checking the result.
+func unaryGrid[T any](f field[T], fn func(x grid[T]) (grid[T], error)) ValueFunc {
+	return func(args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, &ArgumentError{Reason: fmt.Sprintf(
+				"expecting 1 arguments, got %d", len(args))}
+		}
+		x, err := toGrid[T](args[0])
+		if err != nil {
+			return nil, err
+		}
+		result, err := fn(x)
+		if err != nil {
+			return nil, err
+		} else if err := checkGrid(f, result); err != nil {
+			return nil, err
+		}
+		return [][]T(result), nil
+	}
+}
+
+func squareGrid[T any](g grid[T], op string) (int, error) {
+	rows, cols := g.Dims()
+	if rows != cols {
+		return 0, &DimensionError{Reason: fmt.Sprintf(
+			"cannot %s non-square %d-by-%d matrix", op, rows, cols)}
+	}
+	return rows, nil
+}
+
+// Returns inv, det and trace for the elements of any field.
+func fieldFuncs[T any](f field[T]) map[string]ValueFunc {
+	return map[string]ValueFunc{
+		"inv": unaryGrid(f, func(x grid[T]) (grid[T], error) {
+			n, err := squareGrid(x, "invert")
+			if err != nil {
+				return nil, err
+			}
+			return solveGrids(f, x, identityGrid(f, n))
+		}),
+		"det": unaryGrid(f, func(x grid[T]) (grid[T], error) {
+			n, err := squareGrid(x, "take determinant of")
+			if err != nil {
+				return nil, err
+			}
+
+			a, det := x.copy(), f.one()
+			for col := 0; col < n; col++ {
+				pivot := col
+				for row := col + 1; row < n; row++ {
+					if f.greater(a[row][col], a[pivot][col]) {
+						pivot = row
+					}
+				}
+				if f.isZero(a[pivot][col]) {
+					return scalarGrid(f.zero()), nil
+				}
+				if pivot != col {
+					a.swapRows(col, pivot)
+					det = f.neg(det)
+				}
+				det = f.mul(det, a[col][col])
+
+				for row := col + 1; row < n; row++ {
+					r, err := f.quo(a[row][col], a[col][col])
+					if err != nil {
+						return nil, err
+					}
+					for k := col; k < n; k++ {
+						a[row][k] = f.sub(a[row][k], f.mul(r, a[col][k]))
+					}
+				}
+			}
+			return scalarGrid(det), nil
+		}),
+		"trace": unaryGrid(f, func(x grid[T]) (grid[T], error) {
+			n, err := squareGrid(x, "take trace of")
+			if err != nil {
+				return nil, err
+			}
+			total := f.zero()
+			for i := 0; i < n; i++ {
+				total = f.add(total, x[i][i])
+			}
+			return scalarGrid(total), nil
+		}),
+	}
+}
@@ -0,0 +1,143 @@
+package mast
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Formats e as source code for p, such as "-(a + b) * c'", with only the
+// parentheses that the precedence and associativity of its operators require.
+// For any e that p could have parsed, parsing Format(p, e) with p gives back
+// a tree Equal to e. Operators that p does not define are written as String
+// would.
+func Format(p Parser, e Expr) string {
+	return p.format(e, 0)
+}
+
+// Formats e so that it parses at the given precedence, wrapping it in the
+// first pair of parentheses if it would not.
+func (p Parser) format(e Expr, prec int) string {
+	s, level := p.formatLevel(e)
+	if level < prec && len(p.Parens) > 0 {
+		return p.Parens[0].Left + s + p.Parens[0].Right
+	}
+	return s
+}
+
+// Formats e, returning the precedence of its outermost operator. Variables,
+// numbers, applications and brackets bind tightest of all, and equations
+// loosest.
+func (p Parser) formatLevel(e Expr) (string, int) {
+	tightest := len(p.Operators)
+
+	switch e := e.(type) {
+	case *Var:
+		return e.Name, tightest
+
+	case *Num:
+		return e.Text, tightest
+
+	case *Apply:
+		operator := p.format(e.Operator, tightest)
+		if p.isBracket(e.Operand) {
+			return operator + p.format(e.Operand, tightest), tightest
+		} else if len(p.Parens) > 0 {
+			return operator + p.Parens[0].Left + p.format(e.Operand, 0) +
+				p.Parens[0].Right, tightest
+		}
+		return operator + " " + p.format(e.Operand, tightest), tightest
+
+	case *Unary:
+		for _, group := range p.Brackets {
+			if e.Op == group.Left+group.Right {
+				return group.Left + p.format(e.Elem, 0) + group.Right, tightest
+			}
+		}
+		switch prec, typ := p.level(e.Op, Prefix, Suffix); typ {
+		case Prefix:
+			return p.adjoin(e.Op, p.format(e.Elem, prec)), prec
+		case Suffix:
+			return p.adjoin(p.format(e.Elem, prec), e.Op), prec
+		}
+
+	case *Binary:
+		op := " " + e.Op + " "
+		if e.Op == "," {
+			op = ", "
+		}
+		switch prec, typ := p.level(e.Op, InfixLeft, InfixRight); typ {
+		case InfixLeft:
+			return p.format(e.Left, prec) + op + p.format(e.Right, prec+1), prec
+		case InfixRight:
+			return p.format(e.Left, prec+1) + op + p.format(e.Right, prec), prec
+		}
+
+	case *Cond:
+		for prec, op := range p.Operators {
+			if op.Type == Ternary && len(op.Glyphs) == 2 {
+				return p.format(e.Test, prec+1) + " " + op.Glyphs[0] + " " +
+					p.format(e.Then, prec) + " " + op.Glyphs[1] + " " +
+					p.format(e.Else, prec), prec
+			}
+		}
+
+	case *Equation:
+		return p.format(e.Left, 0) + " = " + p.format(e.Right, 0), 0
+	}
+
+	return e.String(), tightest
+}
+
+// Returns the loosest precedence at which glyph is an operator of one of the
+// given types, and which type that is. If it is none, the type is -1.
+func (p Parser) level(glyph string, types ...OpType) (int, OpType) {
+	for prec, op := range p.Operators {
+		for _, typ := range types {
+			if op.Type == typ && isOp(glyph, op.Glyphs) {
+				return prec, typ
+			}
+		}
+	}
+	return 0, -1
+}
+
+// Returns whether e is written with one of the Brackets of p, as "{}" or
+// "{x}" are.
+func (p Parser) isBracket(e Expr) bool {
+	name := ""
+	switch e := e.(type) {
+	case *Var:
+		name = e.Name
+	case *Unary:
+		name = e.Op
+	}
+	for _, group := range p.Brackets {
+		if name == group.Left+group.Right {
+			return true
+		}
+	}
+	return false
+}
+
+// Joins a and b, with a space between if they would otherwise run together
+// into one token, as "not" and "x" or "-" and "-" might.
+func (p Parser) adjoin(a, b string) string {
+	last, _ := utf8.DecodeLastRuneInString(a)
+	first, _ := utf8.DecodeRuneInString(b)
+	if isWord(last) && isWord(first) {
+		return a + " " + b
+	}
+	for _, glyph := range p.glyphs() {
+		for i := 1; i < len(glyph); i++ {
+			if strings.HasSuffix(a, glyph[:i]) && strings.HasPrefix(b, glyph[i:]) {
+				return a + " " + b
+			}
+		}
+	}
+	return a + b
+}
+
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,90 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		Source string
+		Format string
+	}{
+		{"a + b * c", "a + b * c"},
+		{"(a + b) * c", "(a + b) * c"},
+		{"a - (b - c)", "a - (b - c)"},
+		{"(a - b) - c", "a - b - c"},
+		{"a ^ (b ^ c)", "a ^ b ^ c"},
+		{"(a ^ b) ^ c", "(a ^ b) ^ c"},
+		{"-a^2", "-a ^ 2"},
+		{"-(a^2)", "-(a ^ 2)"},
+		{"a - -b", "a - -b"},
+		{"--b", "--b"},
+		{"A''", "A''"},
+		{"(-A)'", "(-A)'"},
+		{"-A'", "-A'"},
+		{"A.' * x", "A.' * x"},
+		{"sin x", "sin(x)"},
+		{"sin(x + y)'", "sin(x + y)'"},
+		{"f(a, b)", "f(a, b)"},
+		{"f{x}", "f{x}"},
+		{"{a, (b, c)}", "{a, (b, c)}"},
+		{"{}", "{}"},
+		{"a < b && !c", "a < b && !c"},
+		{"(a || b) && c", "(a || b) && c"},
+		{"a ? b : c ? d : e", "a ? b : c ? d : e"},
+		{"(a ? b : c) ? d : e", "(a ? b : c) ? d : e"},
+		{"a ? b ? c : d : e", "a ? b ? c : d : e"},
+		{"-(x > 0 ? x : 0)", "-(x > 0 ? x : 0)"},
+		{"0x10 * 2.5e3", "0x10 * 2.5e3"},
+	}
+
+	for _, test := range tests {
+		e := mustParse(t, test.Source)
+		if got := Format(PEMDAS, e); got != test.Format {
+			t.Errorf("formatting %s\ngot       %s\nexpecting %s", test.Source, got, test.Format)
+		} else if again := mustParse(t, got); !Equal(again, e) {
+			t.Errorf("formatting %s gave %s, which parses as %s", test.Source, got, again)
+		}
+	}
+
+	eqn, err := PEMDAS.Parse("y = (A \\ b)'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Format(PEMDAS, eqn); got != "y = (A \\ b)'" {
+		t.Errorf("got %s, expecting y = (A \\ b)'", got)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	x := &Var{Name: "x"}
+	trees := []Expr{
+		&Unary{Op: "-", Elem: &Unary{Op: "'", Elem: x}},
+		&Unary{Op: "'", Elem: &Unary{Op: "-", Elem: x}},
+		&Unary{Op: "-", Elem: &Num{Text: "2", Value: 2}},
+		&Binary{Op: "^", Left: &Unary{Op: "-", Elem: x}, Right: x},
+		&Binary{Op: "*", Left: x, Right: &Binary{Op: "/", Left: x, Right: x}},
+		&Apply{Operator: &Apply{Operator: &Var{Name: "f"}, Operand: x}, Operand: x},
+		&Apply{Operator: &Var{Name: "f"}, Operand: &Apply{Operator: &Var{Name: "g"}, Operand: x}},
+		&Cond{Test: &Cond{Test: x, Then: x, Else: x}, Then: x, Else: x},
+		&Binary{Op: ",", Left: x, Right: &Binary{Op: ",", Left: x, Right: x}},
+	}
+	for _, source := range []string{"sin(w * x)^2 + b", "x ./ (1 - x) .^ 2"} {
+		d, err := Diff(mustParse(t, source), "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees = append(trees, d, Simplify(d))
+	}
+
+	for _, e := range trees {
+		source := Format(PEMDAS, e)
+		again, err := PEMDAS.ParseExpr(source)
+		if err != nil {
+			t.Errorf("%s, while parsing %s formatted from %s", err, source, e)
+		} else if !Equal(again, e) {
+			t.Errorf("formatting %s gave %s, which parses as %s", e, source, again)
+		}
+	}
+}
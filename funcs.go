@@ -0,0 +1,289 @@
+package mast
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Func is a function that can be invoked by name, as in "inv(A)". Calls
+// with several arguments, as in "f(a, b, c)", pass each one separately.
+type Func func(args ...Matrix) (Matrix, error)
+
+// The functions known to every evaluator. Applying any other name is treated
+// as multiplication.
+var builtins = map[string]Func{
+	"inv":   unary(invMat),
+	"det":   unary(detMat),
+	"trace": unary(traceMat),
+	"sin":   elementwise(math.Sin),
+	"cos":   elementwise(math.Cos),
+	"exp":   elementwise(math.Exp),
+	"log":   elementwise(math.Log),
+	"sqrt":  elementwise(math.Sqrt),
+	"abs":   elementwise(math.Abs),
+	"norm":  unary(normMat),
+	"sum":   reduction("sum", 0, func(x, y float64) float64 { return x + y }),
+	"max":   reduction("max", math.Inf(-1), math.Max),
+	"min":   reduction("min", math.Inf(+1), math.Min),
+	"diag":  unary(diagMat),
+	"eye":   constructor(func(i, j int) float64 { return float64(boolToInt(i == j)) }),
+	"zeros": constructor(func(i, j int) float64 { return 0 }),
+	"ones":  constructor(func(i, j int) float64 { return 1 }),
+	"size":  unary(sizeRow),
+	"where": whereMat,
+}
+
+// A MultiFunc is a function that returns several values, which can only be
+// used in a multiple assignment, as in "q, r = qr(A)".
+type MultiFunc func(args ...Matrix) ([]Matrix, error)
+
+// The functions returning several values known to every evaluator.
+var multiBuiltins = map[string]MultiFunc{
+	"qr":   unaryMulti(qrMat),
+	"lu":   unaryMulti(luMat),
+	"eig":  unaryMulti(eigMat),
+	"svd":  unaryMulti(svdMat),
+	"size": unaryMulti(sizeMat),
+}
+
+func arity(args []Matrix, counts ...int) error {
+	for _, n := range counts {
+		if len(args) == n {
+			return nil
+		}
+	}
+
+	want := fmt.Sprint(counts[0])
+	for i, n := range counts[1:] {
+		if i == len(counts)-2 {
+			want += fmt.Sprintf(" or %d", n)
+		} else {
+			want += fmt.Sprintf(", %d", n)
+		}
+	}
+	return &ArgumentError{Reason: fmt.Sprintf(
+		"expecting %s arguments, got %d", want, len(args))}
+}
+
+// Turns a function of a single matrix into a Func.
+func unary(f func(x Matrix) (Matrix, error)) Func {
+	return func(args ...Matrix) (Matrix, error) {
+		if err := arity(args, 1); err != nil {
+			return Matrix{}, err
+		}
+		return f(args[0])
+	}
+}
+
+// Computes where(mask, x, y), which is the same as "mask ? x : y".
+func whereMat(args ...Matrix) (Matrix, error) {
+	if err := arity(args, 3); err != nil {
+		return Matrix{}, err
+	}
+	return selectMats(args[0], args[1], args[2])
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func square(x Matrix, op string) (int, error) {
+	if x.rows != x.cols {
+		return 0, &DimensionError{Reason: fmt.Sprintf(
+			"cannot %s non-square %d-by-%d matrix", op, x.rows, x.cols)}
+	}
+	return x.rows, nil
+}
+
+func invMat(x Matrix) (Matrix, error) {
+	n, err := square(x, "invert")
+	if err != nil {
+		return Matrix{}, err
+	}
+	return solveMats(x, identity(n))
+}
+
+// Computes the determinant by reducing x to upper triangular form.
+func detMat(x Matrix) (Matrix, error) {
+	n, err := square(x, "take determinant of")
+	if err != nil {
+		return Matrix{}, err
+	}
+
+	a := copyMat(x)
+	det := 1.0
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a.At(row, col)) > math.Abs(a.At(pivot, col)) {
+				pivot = row
+			}
+		}
+		if a.At(pivot, col) == 0 {
+			return scalarMat(0), nil
+		}
+		if pivot != col {
+			swapRows(a, col, pivot)
+			det = -det
+		}
+		det *= a.At(col, col)
+
+		for row := col + 1; row < n; row++ {
+			f := a.At(row, col) / a.At(col, col)
+			for k := col; k < n; k++ {
+				a.Set(row, k, a.At(row, k)-f*a.At(col, k))
+			}
+		}
+	}
+	return scalarMat(det), nil
+}
+
+func traceMat(x Matrix) (Matrix, error) {
+	n, err := square(x, "take trace of")
+	if err != nil {
+		return Matrix{}, err
+	}
+	total := 0.0
+	for i := 0; i < n; i++ {
+		total += x.At(i, i)
+	}
+	return scalarMat(total), nil
+}
+
+// Turns a function of a single matrix returning several values into a
+// MultiFunc.
+func unaryMulti(f func(x Matrix) ([]Matrix, error)) MultiFunc {
+	return func(args ...Matrix) ([]Matrix, error) {
+		if err := arity(args, 1); err != nil {
+			return nil, err
+		}
+		return f(args[0])
+	}
+}
+
+// Lifts a scalar function to apply to each element of a matrix.
+func elementwise(f func(float64) float64) Func {
+	return unary(func(x Matrix) (Matrix, error) {
+		result := newMat(x.rows, x.cols)
+		for i := 0; i < x.rows; i++ {
+			for j := 0; j < x.cols; j++ {
+				result.Set(i, j, f(x.At(i, j)))
+			}
+		}
+		return result, nil
+	})
+}
+
+// Computes the Frobenius norm, which for vectors is the Euclidean length.
+func normMat(x Matrix) (Matrix, error) {
+	total := 0.0
+	for i := 0; i < x.rows; i++ {
+		for j := 0; j < x.cols; j++ {
+			total += x.At(i, j) * x.At(i, j)
+		}
+	}
+	return scalarMat(math.Sqrt(total)), nil
+}
+
+// Combines the elements of a vector into a single value, or each column of
+// a matrix into a row vector.
+func reduction(op string, start float64, f func(x, y float64) float64) Func {
+	return unary(func(x Matrix) (Matrix, error) {
+		rows, cols := x.Dims()
+		if rows == 0 || cols == 0 {
+			return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+				"cannot take %s of empty matrix", op)}
+		}
+
+		if rows == 1 || cols == 1 {
+			total := start
+			for i := 0; i < rows; i++ {
+				for j := 0; j < cols; j++ {
+					total = f(total, x.At(i, j))
+				}
+			}
+			return scalarMat(total), nil
+		}
+
+		result := newMat(1, cols)
+		for j := 0; j < cols; j++ {
+			total := start
+			for i := 0; i < rows; i++ {
+				total = f(total, x.At(i, j))
+			}
+			result.Set(0, j, total)
+		}
+		return result, nil
+	})
+}
+
+// Turns a vector into a diagonal matrix, or extracts the diagonal of a
+// matrix as a column vector.
+func diagMat(x Matrix) (Matrix, error) {
+	rows, cols := x.Dims()
+	if rows == 1 || cols == 1 {
+		n := rows * cols
+		result := newMat(n, n)
+		for i := 0; i < n; i++ {
+			if rows == 1 {
+				result.Set(i, i, x.At(0, i))
+			} else {
+				result.Set(i, i, x.At(i, 0))
+			}
+		}
+		return result, nil
+	}
+
+	n := rows
+	if cols < n {
+		n = cols
+	}
+	result := newMat(n, 1)
+	for i := 0; i < n; i++ {
+		result.Set(i, 0, x.At(i, i))
+	}
+	return result, nil
+}
+
+// The most elements a constructor such as eye will allocate.
+const maxConstructed = 1 << 24
+
+// Builds an n-by-n matrix, or an n-by-m matrix when given two arguments.
+func constructor(f func(i, j int) float64) Func {
+	return func(args ...Matrix) (Matrix, error) {
+		if err := arity(args, 1, 2); err != nil {
+			return Matrix{}, err
+		}
+
+		size := []int{}
+		for _, arg := range args {
+			k, ok := scalar(arg)
+			if !ok {
+				return Matrix{}, &DimensionError{Reason: "expecting a scalar size"}
+			} else if k < 0 || k != math.Trunc(k) || k > maxConstructed {
+				return Matrix{}, &ArithmeticError{Reason: fmt.Sprintf(
+					"cannot make a matrix of size %v", k)}
+			}
+			size = append(size, int(k))
+		}
+		if len(size) == 1 {
+			size = append(size, size[0])
+		}
+		if float64(size[0])*float64(size[1]) > maxConstructed {
+			return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+				"cannot make a %d-by-%d matrix, with more than %d elements",
+				size[0], size[1], maxConstructed)}
+		}
+
+		result := newMat(size[0], size[1])
+		for i := 0; i < size[0]; i++ {
+			for j := 0; j < size[1]; j++ {
+				result.Set(i, j, f(i, j))
+			}
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,105 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"math"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	A := [][]float64{{4, 7}, {2, 6}}
+	v := []float64{3, 4}
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Result [][]float64
+	}{
+		{"y = inv(A) * A", []interface{}{&A}, [][]float64{{1, 0}, {0, 1}}},
+		{"y = det A", []interface{}{&A}, [][]float64{{10}}},
+		{"y = trace(A)", []interface{}{&A}, [][]float64{{10}}},
+		{"y = sqrt(A)", []interface{}{&A}, [][]float64{{2, 2.6457513110645907}, {1.4142135623730951, 2.449489742783178}}},
+		{"y = abs(-v)", []interface{}{&v}, [][]float64{{3}, {4}}},
+		{"y = log(exp(v))", []interface{}{&v}, [][]float64{{3}, {4}}},
+		{"y = sin(0) + cos(0)", nil, [][]float64{{1}}},
+		{"y = norm v", []interface{}{&v}, [][]float64{{5}}},
+		{"y = sum(v)", []interface{}{&v}, [][]float64{{7}}},
+		{"y = sum(A)", []interface{}{&A}, [][]float64{{6, 13}}},
+		{"y = max(A)", []interface{}{&A}, [][]float64{{4, 7}}},
+		{"y = min(v)", []interface{}{&v}, [][]float64{{3}}},
+		{"y = diag(v)", []interface{}{&v}, [][]float64{{3, 0}, {0, 4}}},
+		{"y = diag(A)", []interface{}{&A}, [][]float64{{4}, {6}}},
+		{"y = eye(2) + ones(2) - zeros 2", nil, [][]float64{{2, 1}, {1, 2}}},
+		{"y = v' * v", []interface{}{&v}, [][]float64{{25}}},
+	}
+
+	for _, test := range tests {
+		var y [][]float64
+		if err := Eval(test.Source, append([]interface{}{&y}, test.Args...)...); err != nil {
+			t.Errorf("%s, while evaluating %s", err, test.Source)
+			continue
+		}
+		if !closeTo(y, test.Result) {
+			t.Errorf("evaluating %s\ngot       %v\nexpecting %v", test.Source, y, test.Result)
+		}
+	}
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		Source string
+		Error  string
+	}{
+		{"y = eye(1/0)", "cannot make a matrix of size +Inf, in (eye (1 / 0)) at 1:5"},
+		{"y = eye(0/0)", "cannot make a matrix of size NaN, in (eye (0 / 0)) at 1:5"},
+		{"y = eye(1e10)", "cannot make a matrix of size 1e+10, in (eye 1e10) at 1:5"},
+		{"y = ones(-1)", "cannot make a matrix of size -1, in (ones (- 1)) at 1:5"},
+		{"y = zeros(1e6, 1e6)", "cannot make a 1000000-by-1000000 matrix, " +
+			"with more than 16777216 elements, in (zeros (1e6 , 1e6)) at 1:5"},
+	}
+
+	for _, test := range tests {
+		var y [][]float64
+		if err := Eval(test.Source, &y); err == nil {
+			t.Errorf("evaluating %s: expected an error", test.Source)
+		} else if err.Error() != test.Error {
+			t.Errorf("evaluating %s\ngot       %s\nexpecting %s", test.Source, err, test.Error)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	env := NewEnv()
+	env.RegisterFunc("sigmoid", func(args ...Matrix) (Matrix, error) {
+		return NewMatrix(1, 1, []float64{1 / (1 + math.Exp(-args[0].At(0, 0)))}), nil
+	})
+	env.RegisterFunc("sum", func(args ...Matrix) (Matrix, error) {
+		total := 0.0
+		for _, arg := range args {
+			total += arg.At(0, 0)
+		}
+		return NewMatrix(1, 1, []float64{total}), nil
+	})
+
+	y, a := 0.0, 2.0
+	if err := env.Eval("y = sum(a, sigmoid(0), 3, 4) * 2", &y, &a); err != nil {
+		t.Fatal(err)
+	}
+	if y != 19 {
+		t.Errorf("got y = %v, expecting 19", y)
+	}
+
+	// the default environment is unchanged
+	if err := Eval("y = sum(a, a)", &y, &a); err == nil {
+		t.Errorf("expected an error when calling built-in sum with two arguments")
+	} else if _, ok := err.(*ArgumentError); !ok {
+		t.Errorf("expected an *ArgumentError, got %s", err)
+	}
+
+	env.RegisterFunc("pair", func(args ...Matrix) (Matrix, error) {
+		return NewMatrix(2, 1, []float64{1, 2}), nil
+	})
+	if _, ok := env.Eval("y = pair(a)", &y, &a).(*DimensionError); !ok {
+		t.Errorf("expected a *DimensionError from assigning a vector to a scalar")
+	}
+}
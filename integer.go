@@ -0,0 +1,468 @@
+package mast
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// Int64 is an Algebra over int64 scalars. It accepts *int64 and *int
+// arguments, and supports "+", "-", "*", "/" (truncating), "%" (remainder)
+// and "^" (to non-negative powers). Any result that does not fit in an int64
+// gives an *ArithmeticError rather than wrapping around. The comparison and
+// logical operators give 1 if they hold and 0 if not.
+type Int64 struct{}
+
+// Mod is an Algebra over the integers modulo P, whose values are int64s. It
+// accepts *int64 and *int arguments, and reduces every argument, result and
+// operand to the range [0, P), except for exponents: "x^-1" is the inverse
+// of x, while "x^(P-1)" is not. Division multiplies by the modular inverse,
+// and "%" takes the remainder of the representatives in [0, P).
+type Mod struct {
+	// The modulus, which must be at least 2.
+	P int64
+}
+
+// Reads an integer argument, for both Int64 and Mod.
+func readInt(arg interface{}) (int64, error) {
+	switch x := arg.(type) {
+	case *int64:
+		return *x, nil
+	case *int:
+		return int64(*x), nil
+	default:
+		return 0, &UnsupportedTypeError{Value: arg}
+	}
+}
+
+// Stores an integer result, for both Int64 and Mod.
+func writeInt(arg interface{}, v Value) error {
+	n, ok := v.(int64)
+	if !ok {
+		return &UnsupportedTypeError{Value: v}
+	}
+
+	switch x := arg.(type) {
+	case *int64:
+		*x = n
+	case *int:
+		if int64(int(n)) != n {
+			return &ArithmeticError{Reason: fmt.Sprintf("%d overflows int", n)}
+		}
+		*x = int(n)
+	default:
+		return &UnsupportedTypeError{Value: arg}
+	}
+	return nil
+}
+
+// Parses an integer literal, such as "42" or "0xFF".
+func literalInt(n *Num) (int64, error) {
+	if v, err := strconv.ParseInt(n.Text, 0, 64); err == nil {
+		return v, nil
+	}
+	if n.Value == math.Trunc(n.Value) && math.Abs(n.Value) < 1<<53 {
+		return int64(n.Value), nil
+	}
+	return 0, &ArithmeticError{Reason: fmt.Sprintf(
+		"cannot represent %s as an integer", n.Text)}
+}
+
+// Returns the operands of a binary operator on integers.
+func intOperands(x, y Value) (int64, int64, error) {
+	a, ok := x.(int64)
+	if !ok {
+		return 0, 0, &UnsupportedTypeError{Value: x}
+	}
+	b, ok := y.(int64)
+	if !ok {
+		return 0, 0, &UnsupportedTypeError{Value: y}
+	}
+	return a, b, nil
+}
+
+func overflow(op string, a, b int64) error {
+	return &ArithmeticError{Reason: fmt.Sprintf("integer overflow computing %d %s %d", a, op, b)}
+}
+
+// Converts arg into an int64.
+func (Int64) Read(arg interface{}) (Value, error) {
+	n, err := readInt(arg)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Stores the int64 v into arg.
+func (Int64) Write(arg interface{}, v Value) error {
+	return writeInt(arg, v)
+}
+
+// Returns n as an int64, if it is an integer.
+func (Int64) Literal(n *Num) (Value, error) {
+	v, err := literalInt(n)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Computes "-" (negation), "+" (identity) and "!" (logical not).
+func (Int64) Unary(op string, x Value) (Value, error) {
+	a, ok := x.(int64)
+	if !ok {
+		return nil, &UnsupportedTypeError{Value: x}
+	}
+
+	switch op {
+	case "-":
+		if a == math.MinInt64 {
+			return nil, &ArithmeticError{Reason: fmt.Sprintf("integer overflow negating %d", a)}
+		}
+		return -a, nil
+	case "+":
+		return a, nil
+	case "!":
+		return int64(boolToInt(a == 0)), nil
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+}
+
+// Computes the arithmetic operators, checking for overflow, along with the
+// comparison and logical operators.
+func (Int64) Binary(op string, x, y Value) (Value, error) {
+	a, b, err := intOperands(x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "+":
+		r := a + b
+		if (a >= 0) == (b >= 0) && (r >= 0) != (a >= 0) {
+			return nil, overflow(op, a, b)
+		}
+		return r, nil
+	case "-":
+		r := a - b
+		if (a >= 0) != (b >= 0) && (r >= 0) != (a >= 0) {
+			return nil, overflow(op, a, b)
+		}
+		return r, nil
+	case "*":
+		r, err := mulInt(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case "/", "%":
+		if b == 0 {
+			return nil, &ArithmeticError{Reason: "division by zero"}
+		} else if op == "%" {
+			return a % b, nil
+		} else if a == math.MinInt64 && b == -1 {
+			return nil, overflow(op, a, b)
+		}
+		return a / b, nil
+	case "^":
+		if b < 0 {
+			return nil, &ArithmeticError{Reason: fmt.Sprintf(
+				"cannot raise an integer to negative power %d", b)}
+		}
+		result := int64(1)
+		for ; b > 0; b-- {
+			if result, err = mulInt(result, a); err != nil {
+				return nil, err
+			}
+			if result == 0 || result == 1 {
+				break
+			} else if result == -1 {
+				// only the parity of the remaining power matters
+				if (b-1)%2 == 1 {
+					result = 1
+				}
+				break
+			}
+		}
+		return result, nil
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		return compareInts(op, a, b), nil
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+}
+
+// Returns x if mask is nonzero, and y if not.
+func (i Int64) Select(mask, x, y Value) (Value, error) {
+	first, err := i.Choose(mask)
+	if err != nil {
+		return nil, err
+	} else if first {
+		return x, nil
+	}
+	return y, nil
+}
+
+// Reports whether test is nonzero.
+func (Int64) Choose(test Value) (bool, error) {
+	n, ok := test.(int64)
+	if !ok {
+		return false, &UnsupportedTypeError{Value: test}
+	}
+	return n != 0, nil
+}
+
+// Computes a comparison or logical operator, giving 1 if it holds.
+func compareInts(op string, a, b int64) int64 {
+	var holds bool
+	switch op {
+	case "==":
+		holds = a == b
+	case "!=":
+		holds = a != b
+	case "<":
+		holds = a < b
+	case "<=":
+		holds = a <= b
+	case ">":
+		holds = a > b
+	case ">=":
+		holds = a >= b
+	case "&&":
+		holds = a != 0 && b != 0
+	case "||":
+		holds = a != 0 || b != 0
+	}
+	return int64(boolToInt(holds))
+}
+
+func mulInt(a, b int64) (int64, error) {
+	r := a * b
+	if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+		return 0, overflow("*", a, b)
+	}
+	return r, nil
+}
+
+// Returns abs, gcd and where.
+func (Int64) Funcs() map[string]ValueFunc {
+	return map[string]ValueFunc{
+		"where": whereValue(Int64{}),
+		"abs": func(args ...Value) (Value, error) {
+			if len(args) != 1 {
+				return nil, &ArgumentError{Reason: fmt.Sprintf(
+					"expecting 1 arguments, got %d", len(args))}
+			}
+			if a, ok := args[0].(int64); ok && a < 0 {
+				return Int64{}.Unary("-", a)
+			}
+			return args[0], nil
+		},
+		"gcd": func(args ...Value) (Value, error) {
+			if len(args) != 2 {
+				return nil, &ArgumentError{Reason: fmt.Sprintf(
+					"expecting 2 arguments, got %d", len(args))}
+			}
+			a, b, err := intOperands(args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			gcd := new(big.Int).GCD(nil, nil,
+				new(big.Int).Abs(big.NewInt(a)), new(big.Int).Abs(big.NewInt(b)))
+			if !gcd.IsInt64() {
+				return nil, overflow("gcd", a, b)
+			}
+			return gcd.Int64(), nil
+		},
+	}
+}
+
+// Returns no functions.
+func (Int64) MultiFuncs() map[string]MultiValueFunc {
+	return map[string]MultiValueFunc{}
+}
+
+// Reduces n to the range [0, P).
+func (m Mod) reduce(n int64) (int64, error) {
+	if m.P < 2 {
+		return 0, &ArithmeticError{Reason: fmt.Sprintf("invalid modulus %d", m.P)}
+	}
+	if n %= m.P; n < 0 {
+		n += m.P
+	}
+	return n, nil
+}
+
+// Returns the inverse of a modulo P, if there is one.
+func (m Mod) inverse(a int64) (int64, error) {
+	a, err := m.reduce(a)
+	if err != nil {
+		return 0, err
+	}
+	inv := new(big.Int).ModInverse(big.NewInt(a), big.NewInt(m.P))
+	if inv == nil {
+		return 0, &ArithmeticError{Reason: fmt.Sprintf("%d has no inverse modulo %d", a, m.P)}
+	}
+	return inv.Int64(), nil
+}
+
+// Converts arg into an int64.
+func (m Mod) Read(arg interface{}) (Value, error) {
+	n, err := readInt(arg)
+	if err != nil {
+		return nil, err
+	}
+	n, err = m.reduce(n)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Stores the int64 v, reduced modulo P, into arg.
+func (m Mod) Write(arg interface{}, v Value) error {
+	n, ok := v.(int64)
+	if !ok {
+		return &UnsupportedTypeError{Value: v}
+	}
+	n, err := m.reduce(n)
+	if err != nil {
+		return err
+	}
+	return writeInt(arg, n)
+}
+
+// Returns n as an int64, if it is an integer.
+func (m Mod) Literal(n *Num) (Value, error) {
+	return Int64{}.Literal(n)
+}
+
+// Computes "-" (negation), "+" (identity) and "!" (logical not), leaving the
+// result unreduced so that it can be used as an exponent.
+func (m Mod) Unary(op string, x Value) (Value, error) {
+	return Int64{}.Unary(op, x)
+}
+
+// Computes the arithmetic operators modulo P, along with the comparison and
+// logical operators on the representatives in [0, P).
+func (m Mod) Binary(op string, x, y Value) (Value, error) {
+	a, b, err := intOperands(x, y)
+	if err != nil {
+		return nil, err
+	}
+	if a, err = m.reduce(a); err != nil {
+		return nil, err
+	}
+	if op == "^" {
+		return m.pow(a, b)
+	} else if b, err = m.reduce(b); err != nil {
+		return nil, err
+	}
+
+	// the operands are in [0, P), so neither sum nor difference overflows
+	p := uint64(m.P)
+	switch op {
+	case "+":
+		return int64((uint64(a) + uint64(b)) % p), nil
+	case "-":
+		return int64((uint64(a) + p - uint64(b)) % p), nil
+	case "*":
+		return m.mul(a, b), nil
+	case "/":
+		inv, err := m.inverse(b)
+		if err != nil {
+			return nil, err
+		}
+		return m.mul(a, inv), nil
+	case "%":
+		if b == 0 {
+			return nil, &ArithmeticError{Reason: "division by zero"}
+		}
+		return a % b, nil
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+		return compareInts(op, a, b), nil
+	default:
+		return nil, &UnknownOperatorError{Op: op}
+	}
+}
+
+// Returns x if mask is nonzero modulo P, and y if not.
+func (m Mod) Select(mask, x, y Value) (Value, error) {
+	first, err := m.Choose(mask)
+	if err != nil {
+		return nil, err
+	} else if first {
+		return x, nil
+	}
+	return y, nil
+}
+
+// Reports whether test is nonzero modulo P.
+func (m Mod) Choose(test Value) (bool, error) {
+	n, ok := test.(int64)
+	if !ok {
+		return false, &UnsupportedTypeError{Value: test}
+	}
+	n, err := m.reduce(n)
+	if err != nil {
+		return false, err
+	}
+	return n != 0, nil
+}
+
+// Raises a to the power n, inverting a if n is negative.
+func (m Mod) pow(a, n int64) (Value, error) {
+	e := uint64(n)
+	if n < 0 {
+		inv, err := m.inverse(a)
+		if err != nil {
+			return nil, err
+		}
+		a, e = inv, uint64(-n)
+	}
+
+	result := int64(1)
+	for ; e > 0; e /= 2 {
+		if e%2 == 1 {
+			result = m.mul(result, a)
+		}
+		a = m.mul(a, a)
+	}
+	return result, nil
+}
+
+func (m Mod) mul(a, b int64) int64 {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	return int64(bits.Rem64(hi, lo, uint64(m.P)))
+}
+
+// Returns inv, the modular inverse, and where.
+func (m Mod) Funcs() map[string]ValueFunc {
+	return map[string]ValueFunc{
+		"where": whereValue(m),
+		"inv": func(args ...Value) (Value, error) {
+			if len(args) != 1 {
+				return nil, &ArgumentError{Reason: fmt.Sprintf(
+					"expecting 1 arguments, got %d", len(args))}
+			}
+			a, ok := args[0].(int64)
+			if !ok {
+				return nil, &UnsupportedTypeError{Value: args[0]}
+			}
+			inv, err := m.inverse(a)
+			if err != nil {
+				return nil, err
+			}
+			return inv, nil
+		},
+	}
+}
+
+// Returns no functions.
+func (m Mod) MultiFuncs() map[string]MultiValueFunc {
+	return map[string]MultiValueFunc{}
+}
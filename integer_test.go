@@ -0,0 +1,123 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"math"
+	"testing"
+)
+
+func TestInt64(t *testing.T) {
+	env := NewAlgebraEnv(Int64{})
+	x, n := int64(7), 3
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Result int64
+	}{
+		{"y = 1 + 2 * 3", nil, 7},
+		{"y = x / 2", []interface{}{&x}, 3},
+		{"y = -x / 2", []interface{}{&x}, -3},
+		{"y = x % n", []interface{}{&x, &n}, 1},
+		{"y = -x % n", []interface{}{&x, &n}, -1},
+		{"y = 2^62 - 1 + 2^62", nil, math.MaxInt64},
+		{"y = (-1)^(2^62 + 1)", nil, -1},
+		{"y = 0xFF % 0x10", nil, 15},
+		{"y = abs(-x) + gcd(12, -18)", []interface{}{&x}, 13},
+		{"y = x > n ? x : n", []interface{}{&x, &n}, 7},
+		{"y = !x || x % 2 == 1", []interface{}{&x}, 1},
+		{"y = where(x <= n, 1, 2)", []interface{}{&x, &n}, 2},
+	}
+
+	for _, test := range tests {
+		var y int64
+		if err := env.Eval(test.Source, append([]interface{}{&y}, test.Args...)...); err != nil {
+			t.Errorf("%s, while evaluating %s", err, test.Source)
+		} else if y != test.Result {
+			t.Errorf("evaluating %s\ngot       %d\nexpecting %d", test.Source, y, test.Result)
+		}
+	}
+
+	// only the chosen branch is evaluated, so it may guard a division
+	var y, zero int64
+	if err := env.Eval("y = x != 0 ? 10 / x : 0", &y, &zero); err != nil || y != 0 {
+		t.Errorf("got %d, %v, expecting 0", y, err)
+	}
+	if err := env.Eval("y = x == 0 ? 0 : 10 / x", &y, &x); err != nil || y != 1 {
+		t.Errorf("got %d, %v, expecting 1", y, err)
+	}
+
+	errors := []string{
+		"y = 2^63 + x",
+		"y = 3037000500 * 3037000500 + x",
+		"y = 0 - 2^62 - 2^62 - x",
+		"y = x / 0",
+		"y = x % 0",
+		"y = x ^ -1",
+		"y = 1.5 + x",
+	}
+	for _, source := range errors {
+		var y int64
+		if _, ok := env.Eval(source, &y, &x).(*ArithmeticError); !ok {
+			t.Errorf("expected an *ArithmeticError evaluating %s", source)
+		}
+	}
+}
+
+func TestMod(t *testing.T) {
+	env := NewAlgebraEnv(Mod{P: 7})
+	x, n := int64(3), -1
+
+	tests := []struct {
+		Source string
+		Args   []interface{}
+		Result int64
+	}{
+		{"y = 5 + 4", nil, 2},
+		{"y = x - 5", []interface{}{&x}, 5},
+		{"y = n", []interface{}{&n}, 6},
+		{"y = 1 / x", []interface{}{&x}, 5},
+		{"y = x^-1 * x", []interface{}{&x}, 1},
+		{"y = inv(x) - x^5", []interface{}{&x}, 0},
+		{"y = 2^5", nil, 4},
+		{"y = 13 % 4", nil, 2},
+		{"y = -x", []interface{}{&x}, 4},
+		{"y = x == 10 ? 1 : 2", []interface{}{&x}, 1},
+	}
+
+	for _, test := range tests {
+		var y int64
+		if err := env.Eval(test.Source, append([]interface{}{&y}, test.Args...)...); err != nil {
+			t.Errorf("%s, while evaluating %s", err, test.Source)
+		} else if y != test.Result {
+			t.Errorf("evaluating %s\ngot       %d\nexpecting %d", test.Source, y, test.Result)
+		}
+	}
+
+	// multiplication must not overflow for large moduli
+	var y int64
+	large := NewAlgebraEnv(Mod{P: 1<<61 - 1})
+	if err := large.Eval("y = (0 - 1) * (0 - 1)", &y); err != nil || y != 1 {
+		t.Errorf("got %d, %v, expecting 1", y, err)
+	}
+
+	errors := []struct {
+		Env    *Env
+		Source string
+	}{
+		{env, "y = 1 / 0"},
+		{env, "y = 0^-1"},
+		{env, "y = 1 % 7"},
+		{env, "y = 1e400"},
+		{NewAlgebraEnv(Mod{P: 9}), "y = 1 / 3"},
+		{NewAlgebraEnv(Mod{}), "y = 1 + 1"},
+		{NewAlgebraEnv(Mod{}), "y = 1 ? 2 : 3"},
+		{NewAlgebraEnv(Mod{P: -5}), "y = 1 ? 2 : 3"},
+		{NewAlgebraEnv(Mod{P: 0}), "y = inv(-3)"},
+	}
+	for _, test := range errors {
+		if _, ok := test.Env.Eval(test.Source, &y).(*ArithmeticError); !ok {
+			t.Errorf("expected an *ArithmeticError evaluating %s", test.Source)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package mast
+
+import (
+	"fmt"
+	"math"
+)
+
+// A Matrix is the evaluator's representation of a value: a rows-by-cols grid
+// of numbers. Scalars are 1-by-1 matrices, and vectors are n-by-1 column
+// matrices.
+//
+// The elements are stored in a single contiguous slice, where element (i, j)
+// is found at i*rowStride + j*colStride. This lets a transpose share storage
+// with the original rather than being copied, so a Matrix should be treated
+// as read-only unless it was created by NewMatrix. An evaluator writes each
+// result to a *Matrix as a fresh copy, which is never shared with its inputs.
+type Matrix struct {
+	data      []float64
+	rows      int
+	cols      int
+	rowStride int
+	colStride int
+}
+
+// Creates a rows-by-cols Matrix from the given elements, listed one row after
+// another. If data is nil, the Matrix is filled with zeros. Panics if data
+// has the wrong length.
+func NewMatrix(rows, cols int, data []float64) Matrix {
+	if data == nil {
+		data = make([]float64, rows*cols)
+	} else if len(data) != rows*cols {
+		panic(fmt.Sprintf("cannot make a %d-by-%d matrix from %d elements",
+			rows, cols, len(data)))
+	}
+	return Matrix{data, rows, cols, cols, 1}
+}
+
+// Returns the number of rows and columns in this Matrix.
+func (m Matrix) Dims() (rows, cols int) {
+	return m.rows, m.cols
+}
+
+// Returns the element at row i, column j, counting from zero. Panics if
+// either is out of range.
+func (m Matrix) At(i, j int) float64 {
+	return m.data[m.index(i, j)]
+}
+
+// Sets the element at row i, column j, counting from zero. Panics if either
+// is out of range.
+func (m Matrix) Set(i, j int, v float64) {
+	m.data[m.index(i, j)] = v
+}
+
+// Returns where element (i, j) is stored, checking each index separately,
+// since an out-of-range column could otherwise land in the next row.
+func (m Matrix) index(i, j int) int {
+	if i < 0 || i >= m.rows || j < 0 || j >= m.cols {
+		panic(fmt.Sprintf("index (%d, %d) out of range for a %d-by-%d matrix",
+			i, j, m.rows, m.cols))
+	}
+	return i*m.rowStride + j*m.colStride
+}
+
+// Returns the transpose of this Matrix, which shares its storage.
+func (m Matrix) T() Matrix {
+	return Matrix{m.data, m.cols, m.rows, m.colStride, m.rowStride}
+}
+
+// Copies the elements of this Matrix into a slice of rows.
+func (m Matrix) Slices() [][]float64 {
+	result := make([][]float64, m.rows)
+	for i := range result {
+		result[i] = make([]float64, m.cols)
+		for j := range result[i] {
+			result[i][j] = m.At(i, j)
+		}
+	}
+	return result
+}
+
+// Represent this Matrix as a string, one row at a time.
+func (m Matrix) String() string {
+	return fmt.Sprint(m.Slices())
+}
+
+func newMat(rows, cols int) Matrix {
+	return NewMatrix(rows, cols, nil)
+}
+
+func scalarMat(v float64) Matrix {
+	return Matrix{[]float64{v}, 1, 1, 1, 1}
+}
+
+// Returns a contiguous copy of a, which can be safely modified.
+func copyMat(a Matrix) Matrix {
+	result := newMat(a.rows, a.cols)
+	for i := 0; i < a.rows; i++ {
+		for j := 0; j < a.cols; j++ {
+			result.Set(i, j, a.At(i, j))
+		}
+	}
+	return result
+}
+
+// Swaps rows i and j of a.
+func swapRows(a Matrix, i, j int) {
+	for k := 0; k < a.cols; k++ {
+		x, y := a.At(i, k), a.At(j, k)
+		a.Set(i, k, y)
+		a.Set(j, k, x)
+	}
+}
+
+// Reports that row i of a slice of rows has n elements rather than cols.
+func raggedError(cols, i, n int) error {
+	return &DimensionError{Reason: fmt.Sprintf(
+		"array size mismatch: [0] was an %d-slice, [%d] was an %d-slice", cols, i, n)}
+}
+
+func readMat(x interface{}) (Matrix, error) {
+	switch x := x.(type) {
+	case *float64:
+		return scalarMat(*x), nil
+	case *[]float64:
+		// copy, since the same slice may be overwritten by a later output
+		return NewMatrix(len(*x), 1, append([]float64{}, *x...)), nil
+	case *[][]float64:
+		rows, cols := len(*x), 0
+		if rows > 0 {
+			cols = len((*x)[0])
+		}
+		result := newMat(rows, cols)
+		for i, r := range *x {
+			if len(r) != cols {
+				return Matrix{}, raggedError(cols, i, len(r))
+			}
+			copy(result.data[i*cols:], r)
+		}
+		return result, nil
+	case *Matrix:
+		return *x, nil
+	default:
+		return Matrix{}, &UnsupportedTypeError{Value: x}
+	}
+}
+
+func writeMat(x interface{}, result Matrix) error {
+	switch x := x.(type) {
+	case *float64:
+		if v, ok := scalar(result); ok {
+			*x = v
+		} else {
+			return &DimensionError{Reason: "attempt to assign non-scalar value to scalar"}
+		}
+	case *[]float64:
+		if result.cols != 1 {
+			return &DimensionError{Reason: "attempt to assign non-vector value to vector"}
+		} else if *x == nil {
+			*x = make([]float64, result.rows)
+		} else if result.rows != len(*x) {
+			return &DimensionError{Reason: "attempt to assign vectors of differing size"}
+		}
+
+		for i := range *x {
+			(*x)[i] = result.At(i, 0)
+		}
+	case *[][]float64:
+		// reuse the existing storage when it is the right shape
+		same := len(*x) == result.rows
+		for _, row := range *x {
+			same = same && len(row) == result.cols
+		}
+		if !same {
+			*x = result.Slices()
+			return nil
+		}
+		for i, row := range *x {
+			for j := range row {
+				row[j] = result.At(i, j)
+			}
+		}
+	case *Matrix:
+		// copy, since result may share storage with an input, as in "B = A'"
+		*x = copyMat(result)
+	default:
+		return &UnsupportedTypeError{Value: x}
+	}
+	return nil
+}
+
+func zipMats(a, b Matrix, op string, f func(x, y float64) float64) (Matrix, error) {
+	if a.rows != b.rows || a.cols != b.cols {
+		return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+			"cannot %s %d-by-%d and %d-by-%d matrices", op, a.rows, a.cols, b.rows, b.cols)}
+	}
+
+	result := newMat(a.rows, a.cols)
+	for i := 0; i < a.rows; i++ {
+		for j := 0; j < a.cols; j++ {
+			result.Set(i, j, f(a.At(i, j), b.At(i, j)))
+		}
+	}
+	return result, nil
+}
+
+// Returns a view of a stretched to rows-by-cols, by repeating a along each
+// dimension where it has size 1.
+func stretch(a Matrix, rows, cols int) Matrix {
+	if a.rows == 1 {
+		a.rows, a.rowStride = rows, 0
+	}
+	if a.cols == 1 {
+		a.cols, a.colStride = cols, 0
+	}
+	return a
+}
+
+// Anything with rows and columns, such as a Matrix or the grid of another
+// Algebra, which can be broadcast.
+type shaped interface {
+	Dims() (rows, cols int)
+}
+
+// Returns the size that the operands broadcast to: each dimension must
+// either match, or be 1 in some of the operands.
+func broadcastDims(op string, operands ...shaped) (int, int, error) {
+	rows, cols := 1, 1
+	for _, m := range operands {
+		r, c := m.Dims()
+		if r != 1 {
+			rows = r
+		}
+		if c != 1 {
+			cols = c
+		}
+	}
+
+	for _, m := range operands {
+		if r, c := m.Dims(); (r != rows && r != 1) || (c != cols && c != 1) {
+			sizes := ""
+			for i, m := range operands {
+				if i == len(operands)-1 {
+					sizes += " and "
+				} else if i > 0 {
+					sizes += ", "
+				}
+				r, c := m.Dims()
+				sizes += fmt.Sprintf("%d-by-%d", r, c)
+			}
+			return 0, 0, &DimensionError{Reason: fmt.Sprintf(
+				"cannot %s %s matrices", op, sizes)}
+		}
+	}
+	return rows, cols, nil
+}
+
+// Like zipMats, but broadcasting scalars, row vectors and column vectors
+// across the other operand: each dimension must either match, or be 1 in
+// one of the operands.
+func broadcastMats(a, b Matrix, op string, f func(x, y float64) float64) (Matrix, error) {
+	rows, cols, err := broadcastDims(op, a, b)
+	if err != nil {
+		return Matrix{}, err
+	}
+	return zipMats(stretch(a, rows, cols), stretch(b, rows, cols), op, f)
+}
+
+func addMats(a, b Matrix) (Matrix, error) {
+	return broadcastMats(a, b, "add", func(x, y float64) float64 { return x + y })
+}
+
+func subMats(a, b Matrix) (Matrix, error) {
+	return broadcastMats(a, b, "subtract", func(x, y float64) float64 { return x - y })
+}
+
+func timesMats(a, b Matrix) (Matrix, error) {
+	return broadcastMats(a, b, "multiply elementwise", func(x, y float64) float64 { return x * y })
+}
+
+func quoMats(a, b Matrix) (Matrix, error) {
+	return broadcastMats(a, b, "divide elementwise", func(x, y float64) float64 { return x / y })
+}
+
+func raiseMats(a, b Matrix) (Matrix, error) {
+	return broadcastMats(a, b, "raise elementwise", math.Pow)
+}
+
+// The comparison and logical operators, where any nonzero value is true.
+var comparisons = map[string]func(x, y float64) bool{
+	"==": func(x, y float64) bool { return x == y },
+	"!=": func(x, y float64) bool { return x != y },
+	"<":  func(x, y float64) bool { return x < y },
+	"<=": func(x, y float64) bool { return x <= y },
+	">":  func(x, y float64) bool { return x > y },
+	">=": func(x, y float64) bool { return x >= y },
+	"&&": func(x, y float64) bool { return x != 0 && y != 0 },
+	"||": func(x, y float64) bool { return x != 0 || y != 0 },
+}
+
+// Compares a and b elementwise with broadcasting, giving a mask that is 1
+// where the comparison holds and 0 elsewhere.
+func compareMats(op string, a, b Matrix) (Matrix, error) {
+	cmp := comparisons[op]
+	return broadcastMats(a, b, "compare", func(x, y float64) float64 {
+		return float64(boolToInt(cmp(x, y)))
+	})
+}
+
+// Negates the mask a, giving 1 where it is zero and 0 elsewhere.
+func notMat(a Matrix) Matrix {
+	result := newMat(a.rows, a.cols)
+	for i := 0; i < a.rows; i++ {
+		for j := 0; j < a.cols; j++ {
+			result.Set(i, j, float64(boolToInt(a.At(i, j) == 0)))
+		}
+	}
+	return result
+}
+
+// Chooses each element from a where mask is nonzero, and from b elsewhere,
+// broadcasting all three.
+func selectMats(mask, a, b Matrix) (Matrix, error) {
+	rows, cols, err := broadcastDims("select from", mask, a, b)
+	if err != nil {
+		return Matrix{}, err
+	}
+	mask, a, b = stretch(mask, rows, cols), stretch(a, rows, cols), stretch(b, rows, cols)
+
+	result := newMat(rows, cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if mask.At(i, j) != 0 {
+				result.Set(i, j, a.At(i, j))
+			} else {
+				result.Set(i, j, b.At(i, j))
+			}
+		}
+	}
+	return result, nil
+}
+
+func scaleMat(k float64, a Matrix) Matrix {
+	result := newMat(a.rows, a.cols)
+	for i := 0; i < a.rows; i++ {
+		for j := 0; j < a.cols; j++ {
+			result.Set(i, j, k*a.At(i, j))
+		}
+	}
+	return result
+}
+
+// Returns the scalar value of x, if it is 1-by-1.
+func scalar(x Matrix) (float64, bool) {
+	if x.rows == 1 && x.cols == 1 {
+		return x.At(0, 0), true
+	}
+	return 0, false
+}
+
+func multMats(a, b Matrix) (Matrix, error) {
+	if k, ok := scalar(a); ok {
+		return scaleMat(k, b), nil
+	} else if k, ok := scalar(b); ok {
+		return scaleMat(k, a), nil
+	}
+
+	if a.cols != b.rows {
+		return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+			"cannot multiply %d-by-%d and %d-by-%d matrices", a.rows, a.cols, b.rows, b.cols)}
+	}
+
+	result := newMat(a.rows, b.cols)
+	for i := 0; i < a.rows; i++ {
+		for j := 0; j < b.cols; j++ {
+			total := 0.0
+			for k := 0; k < a.cols; k++ {
+				total += a.At(i, k) * b.At(k, j)
+			}
+			result.Set(i, j, total)
+		}
+	}
+	return result, nil
+}
+
+func identity(n int) Matrix {
+	result := newMat(n, n)
+	for i := 0; i < n; i++ {
+		result.Set(i, i, 1)
+	}
+	return result
+}
+
+// Solves a * x = b for x (that is, computes a\b), using Gaussian elimination
+// with partial pivoting.
+func solveMats(a, b Matrix) (Matrix, error) {
+	if k, ok := scalar(a); ok {
+		return scaleMat(1/k, b), nil
+	}
+
+	n, mb := a.rows, b.cols
+	if a.rows != a.cols {
+		return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+			"cannot solve with non-square %d-by-%d matrix", a.rows, a.cols)}
+	} else if a.rows != b.rows {
+		return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+			"cannot solve %d-by-%d and %d-by-%d matrices", a.rows, a.cols, b.rows, b.cols)}
+	}
+
+	// work on copies of both sides, so that the inputs are untouched
+	lhs, rhs := copyMat(a), copyMat(b)
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(lhs.At(row, col)) > math.Abs(lhs.At(pivot, col)) {
+				pivot = row
+			}
+		}
+		if lhs.At(pivot, col) == 0 {
+			return Matrix{}, &ArithmeticError{Reason: "cannot solve with singular matrix"}
+		}
+		swapRows(lhs, col, pivot)
+		swapRows(rhs, col, pivot)
+
+		for row := col + 1; row < n; row++ {
+			f := lhs.At(row, col) / lhs.At(col, col)
+			for k := col; k < n; k++ {
+				lhs.Set(row, k, lhs.At(row, k)-f*lhs.At(col, k))
+			}
+			for k := 0; k < mb; k++ {
+				rhs.Set(row, k, rhs.At(row, k)-f*rhs.At(col, k))
+			}
+		}
+	}
+
+	for col := n - 1; col >= 0; col-- {
+		for k := 0; k < mb; k++ {
+			v := rhs.At(col, k)
+			for j := col + 1; j < n; j++ {
+				v -= lhs.At(col, j) * rhs.At(j, k)
+			}
+			rhs.Set(col, k, v/lhs.At(col, col))
+		}
+	}
+	return rhs, nil
+}
+
+// Computes a/b, which is the same as (b' \ a')'.
+func divMats(a, b Matrix) (Matrix, error) {
+	if k, ok := scalar(b); ok {
+		return scaleMat(1/k, a), nil
+	}
+	x, err := solveMats(b.T(), a.T())
+	if err != nil {
+		return Matrix{}, err
+	}
+	return x.T(), nil
+}
+
+// Computes a^b, where either both are scalars, or a is a square matrix and b
+// is an integer.
+func powMats(a, b Matrix) (Matrix, error) {
+	n, ok := scalar(b)
+	if !ok {
+		return Matrix{}, &DimensionError{Reason: "cannot raise to a non-scalar power"}
+	}
+	if k, ok := scalar(a); ok {
+		return scalarMat(math.Pow(k, n)), nil
+	}
+
+	if a.rows != a.cols {
+		return Matrix{}, &DimensionError{Reason: fmt.Sprintf(
+			"cannot raise non-square %d-by-%d matrix to a power", a.rows, a.cols)}
+	} else if n != math.Trunc(n) {
+		return Matrix{}, &ArithmeticError{Reason: fmt.Sprintf(
+			"cannot raise a matrix to non-integer power %v", n)}
+	} else if math.Abs(n) >= 1<<53 {
+		// beyond this, n is not exact, and infinity would never halve to zero
+		return Matrix{}, &ArithmeticError{Reason: fmt.Sprintf(
+			"cannot raise a matrix to power %v, which is too large", n)}
+	}
+
+	var err error
+	if n < 0 {
+		if a, err = solveMats(a, identity(a.rows)); err != nil {
+			return Matrix{}, err
+		}
+		n = -n
+	}
+
+	// exponentiation by squaring (neither can fail, as a is square)
+	result := identity(a.rows)
+	for ; n > 0; n = math.Floor(n / 2) {
+		if math.Mod(n, 2) == 1 {
+			result, _ = multMats(result, a)
+		}
+		a, _ = multMats(a, a)
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"reflect"
+	"testing"
+)
+
+func TestMatrix(t *testing.T) {
+	m := NewMatrix(2, 3, []float64{1, 2, 3, 4, 5, 6})
+	if rows, cols := m.Dims(); rows != 2 || cols != 3 {
+		t.Errorf("got %d-by-%d, expecting 2-by-3", rows, cols)
+	}
+	if m.At(1, 0) != 4 {
+		t.Errorf("got m.At(1, 0) = %v, expecting 4", m.At(1, 0))
+	}
+
+	// the transpose is a view sharing storage with m
+	mt := m.T()
+	if rows, cols := mt.Dims(); rows != 3 || cols != 2 {
+		t.Errorf("got %d-by-%d transpose, expecting 3-by-2", rows, cols)
+	}
+	m.Set(0, 2, 9)
+	if mt.At(2, 0) != 9 {
+		t.Errorf("got mt.At(2, 0) = %v, expecting 9", mt.At(2, 0))
+	}
+	if got := mt.Slices(); !reflect.DeepEqual(got, [][]float64{{1, 4}, {2, 5}, {9, 6}}) {
+		t.Errorf("got %v", got)
+	}
+
+	if got := NewMatrix(2, 2, nil).String(); got != "[[0 0] [0 0]]" {
+		t.Errorf("got %s, expecting zeros", got)
+	}
+
+	// (0, 3) would otherwise be stored where (1, 0) is
+	for _, index := range [][2]int{{0, 3}, {2, 0}, {-1, 1}, {1, -1}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("m.At(%d, %d) did not panic", index[0], index[1])
+				}
+			}()
+			m.At(index[0], index[1])
+		}()
+	}
+}
+
+func TestMatrixArgs(t *testing.T) {
+	A := NewMatrix(2, 2, []float64{1, 2, 3, 4})
+	var B Matrix
+	if err := Eval("B = A' * A", &B, &A); err != nil {
+		t.Fatal(err)
+	}
+	if got := B.Slices(); !reflect.DeepEqual(got, [][]float64{{10, 14}, {14, 20}}) {
+		t.Errorf("got B = %v", got)
+	}
+
+	// results never share storage with the arguments they came from
+	for _, code := range []string{"B = A'", "B = +A", "B = A"} {
+		if err := Eval(code, &B, &A); err != nil {
+			t.Fatal(err)
+		}
+		B.Set(0, 1, 7)
+		if got := A.Slices(); !reflect.DeepEqual(got, [][]float64{{1, 2}, {3, 4}}) {
+			t.Errorf("setting B after %s changed A to %v", code, got)
+		}
+	}
+
+	// outputs are written in place when they have the right shape
+	C := [][]float64{{0, 0}, {0, 0}}
+	row := C[0]
+	if err := Eval("C = A'", &C, &A); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(C, [][]float64{{1, 3}, {2, 4}}) || row[1] != 3 {
+		t.Errorf("got C = %v", C)
+	}
+
+	// swapping vectors reads both before writing either
+	a, b := []float64{1, 2}, []float64{3, 4}
+	if err := Eval("a, b = b, a", &a, &b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, []float64{3, 4}) || !reflect.DeepEqual(b, []float64{1, 2}) {
+		t.Errorf("got a = %v, b = %v", a, b)
+	}
+}
+
+func BenchmarkTranspose(b *testing.B) {
+	data := make([]float64, 500*500)
+	for i := range data {
+		data[i] = float64(i)
+	}
+	A := NewMatrix(500, 500, data)
+	x := NewMatrix(500, 1, data[:500])
+	prog := MustCompile("y = A' * x")
+
+	var y Matrix
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		prog.MustRun(&y, &A, &x)
+	}
+}
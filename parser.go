@@ -1,7 +1,11 @@
 package mast
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -28,6 +32,10 @@ type Parser struct {
 	// If true, then "sin x" is legal and parses as "sin(x)" would. If false,
 	// that is a syntax error.
 	AdjacentIsApplication bool
+
+	// If true, then metavariables such as "?a" are also legal, and "->"
+	// separates the two sides of a Rule; see ParsePattern.
+	patterns bool
 }
 
 // PEMDAS defines a typical multiply-first math language.
@@ -41,11 +49,15 @@ var PEMDAS Parser = Parser{
 	},
 	Operators: []Prec{
 		{[]string{","}, InfixLeft},
+		{[]string{"?", ":"}, Ternary},
+		{[]string{"||"}, InfixLeft},
+		{[]string{"&&"}, InfixLeft},
+		{[]string{"==", "!=", "<", "<=", ">", ">="}, InfixLeft},
 		{[]string{"+", "-"}, InfixLeft},
-		{[]string{"*", "/", "\\"}, InfixLeft},
-		{[]string{"^"}, InfixRight},
-		{[]string{"-", "+"}, Prefix},
-		{[]string{"'"}, Suffix},
+		{[]string{"*", "/", "\\", "%", ".*", "./"}, InfixLeft},
+		{[]string{"^", ".^"}, InfixRight},
+		{[]string{"-", "+", "!"}, Prefix},
+		{[]string{"'", ".'"}, Suffix},
 	},
 	AdjacentIsApplication: true,
 }
@@ -80,6 +92,10 @@ const (
 
 	// eg. A'' = (A')'
 	Suffix
+
+	// eg. a ? b : c ? d : e == a ? b : (c ? d : e), where Glyphs holds the
+	// pair of operators, such as {"?", ":"}
+	Ternary
 )
 
 // The Syntax tree returned by .Parse() is composed of Expr elements.
@@ -87,9 +103,14 @@ const (
 //
 //   Apply   sin(t)
 //   Var     x
+//   Num     3.14
 //   Unary   -w
 //   Binary  a + b
+//   Cond    x > 0 ? x : 0
 //
+// Each records the Span of source code it was parsed from. Use Walk or
+// Transform to traverse a tree without switching on each type, and Format
+// to write it back as source code.
 type Expr interface {
 	String() string
 }
@@ -98,6 +119,7 @@ type Expr interface {
 // multiple letters.
 type Var struct {
 	Name string
+	Span Span
 }
 
 // Represent this Var as a context.
@@ -105,6 +127,20 @@ func (v *Var) String() string {
 	return v.Name
 }
 
+// A Num is a numeric literal, such as 42, 3.14, 1e-9 or 0x1F. Text is the
+// literal as it was written, and Value is what it denotes, rounded to the
+// nearest float64, or +Inf if it is too large for one.
+type Num struct {
+	Text  string
+	Value float64
+	Span  Span
+}
+
+// Represent this Num as it was written.
+func (n *Num) String() string {
+	return n.Text
+}
+
 // Represents function application, where an expression is invoked as an
 // operator. Examples:
 //
@@ -114,6 +150,7 @@ func (v *Var) String() string {
 type Apply struct {
 	Operator Expr
 	Operand  Expr
+	Span     Span
 }
 
 // Represents this application as a string.
@@ -129,6 +166,7 @@ func (a *Apply) String() string {
 type Unary struct {
 	Op   string
 	Elem Expr
+	Span Span
 }
 
 // Represent this unary operator as a string.
@@ -145,6 +183,7 @@ type Binary struct {
 	Op    string
 	Left  Expr
 	Right Expr
+	Span  Span
 }
 
 // Represent this binary operator as a string.
@@ -152,6 +191,22 @@ func (o *Binary) String() string {
 	return fmt.Sprintf("(%s %s %s)", o.Left, o.Op, o.Right)
 }
 
+// A Cond chooses between two expressions by a condition. Example:
+//
+//   a < b ? a : b == Cond{Binary{"<", Var{"a"}, Var{"b"}}, Var{"a"}, Var{"b"}}
+//
+type Cond struct {
+	Test Expr
+	Then Expr
+	Else Expr
+	Span Span
+}
+
+// Represent this conditional as a string.
+func (c *Cond) String() string {
+	return fmt.Sprintf("(%s ? %s : %s)", c.Test, c.Then, c.Else)
+}
+
 // An equation is an assignment of one side to the other. The engine provided
 // can only evaluate an equation with a single variable on the left, but more
 // advanced algebra systems could go further. Example:
@@ -161,6 +216,7 @@ func (o *Binary) String() string {
 type Equation struct {
 	Left  Expr
 	Right Expr
+	Span  Span
 }
 
 // Represent this Equation as a string.
@@ -168,15 +224,95 @@ func (e *Equation) String() string {
 	return fmt.Sprintf("%s = %s", e.Left, e.Right)
 }
 
+// Returns the Span of source code that the given Expr was parsed from, or the
+// zero Span if it was not parsed.
+func spanOf(e Expr) Span {
+	switch e := e.(type) {
+	case *Var:
+		return e.Span
+	case *Num:
+		return e.Span
+	case *Apply:
+		return e.Span
+	case *Unary:
+		return e.Span
+	case *Binary:
+		return e.Span
+	case *Cond:
+		return e.Span
+	case *Equation:
+		return e.Span
+	}
+	return Span{}
+}
+
+// Widens the Span of e to cover s, such as when e was wrapped in parentheses.
+func setSpan(e Expr, s Span) {
+	switch e := e.(type) {
+	case *Var:
+		e.Span = s
+	case *Num:
+		e.Span = s
+	case *Apply:
+		e.Span = s
+	case *Unary:
+		e.Span = s
+	case *Binary:
+		e.Span = s
+	case *Cond:
+		e.Span = s
+	case *Equation:
+		e.Span = s
+	}
+}
+
+// Returns the Span running from the start of a to the end of b.
+func join(a, b Expr) Span {
+	return Span{spanOf(a).Start, spanOf(b).End}
+}
+
 func isVar(s string) bool {
-	for _, c := range s {
-		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+	for i, c := range s {
+		if !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
 			return false
 		}
 	}
 	return s != ""
 }
 
+// Returns whether s is a metavariable, such as "?a", in a pattern.
+func isMeta(s string) bool {
+	return len(s) > 1 && s[0] == '?' && isVar(s[1:])
+}
+
+func isNum(s string) bool {
+	return s != "" && (isDecimal(rune(s[0])) ||
+		s[0] == '.' && len(s) > 1 && isDecimal(rune(s[1])))
+}
+
+func parseNum(t token) (*Num, error) {
+	var value float64
+	var err error
+
+	if strings.HasPrefix(t.text, "0x") || strings.HasPrefix(t.text, "0X") {
+		var u uint64
+		u, err = strconv.ParseUint(t.text, 0, 64)
+		value = float64(u)
+	} else {
+		value, err = strconv.ParseFloat(t.text, 64)
+	}
+
+	// a literal too large for float64 may still suit an Algebra that reads
+	// Text, such as BigRat, so leave it to the Algebra to reject
+	if errors.Is(err, strconv.ErrRange) {
+		value, err = math.Inf(1), nil
+	}
+	if err != nil {
+		return nil, unexpected(t, "a representable number")
+	}
+	return &Num{t.text, value, t.span}, nil
+}
+
 func isEq(s string) bool {
 	return s == "="
 }
@@ -202,26 +338,78 @@ func isOp(s string, ops []string) bool {
 type Unexpected struct {
 	Found     string
 	Expecting string
+
+	// Where the unexpected token begins, and the source code it is in.
+	Pos    Pos
+	Source string
 }
 
-// Represent this Unexpected as a string.
+func unexpected(t token, expecting string) *Unexpected {
+	return &Unexpected{Found: t.text, Expecting: expecting, Pos: t.span.Start}
+}
+
+// Represent this Unexpected as a string. If the Source is known, the offending
+// line is printed as well, with a caret under the unexpected token.
 func (u Unexpected) Error() string {
-	found := u.Found
-	if found == "" {
-		found = "end-of-input"
+	result := "unexpected end-of-input"
+	if u.Found != "" {
+		result = fmt.Sprintf("unexpected %#v", u.Found)
 	}
-	result := fmt.Sprintf("unexpected %#v", u.Found)
 	if u.Expecting != "" {
 		result += fmt.Sprintf(", expecting %s", u.Expecting)
 	}
+	if u.Pos.Line > 0 {
+		result += fmt.Sprintf(" at %s", u.Pos)
+	}
+	if u.Source != "" && u.Pos.Offset <= len(u.Source) {
+		result += "\n" + caret(u.Source, u.Pos.Offset)
+	}
 	return result
 }
 
-func (p Parser) parseSingle(tokens []string, inApp bool) (lo []string, e Expr, err error) {
-	// Look for a single variable
-	if isVar(tokens[0]) {
+// Draws the line of source containing offset, and a caret pointing to it.
+func caret(source string, offset int) string {
+	start := strings.LastIndex(source[:offset], "\n") + 1
+	end := strings.Index(source[offset:], "\n")
+	if end < 0 {
+		end = len(source)
+	} else {
+		end += offset
+	}
+
+	pad := []rune{}
+	for _, c := range source[start:offset] {
+		if c == '\t' {
+			pad = append(pad, '\t')
+		} else {
+			pad = append(pad, ' ')
+		}
+	}
+
+	line := strings.TrimSuffix(source[start:end], "\r")
+	return line + "\n" + string(pad) + "^"
+}
+
+// Fills in the source code of any Unexpected error.
+func withSource(err error, source string) error {
+	if u, ok := err.(*Unexpected); ok {
+		u.Source = source
+	}
+	return err
+}
+
+func (p Parser) parseSingle(tokens []token, inApp bool) (lo []token, e Expr, err error) {
+	// Look for a single variable or number
+	if isVar(tokens[0].text) || isNum(tokens[0].text) || p.patterns && isMeta(tokens[0].text) {
 		lo = tokens[1:]
-		e = &Var{tokens[0]}
+		if isNum(tokens[0].text) {
+			e, err = parseNum(tokens[0])
+			if err != nil {
+				return tokens, nil, err
+			}
+		} else {
+			e = &Var{tokens[0].text, tokens[0].span}
+		}
 		var e2 Expr
 
 		if inApp {
@@ -231,18 +419,20 @@ func (p Parser) parseSingle(tokens []string, inApp bool) (lo []string, e Expr, e
 		for {
 			apply := false
 			for _, group := range append(p.Parens, p.Brackets...) {
-				if lo[0] == group.Left {
+				if lo[0].text == group.Left {
 					apply = true
 					break
 				}
 			}
 
-			if apply || (isVar(lo[0]) && p.AdjacentIsApplication) {
+			adjacent := isVar(lo[0].text) || isNum(lo[0].text) ||
+				p.patterns && isMeta(lo[0].text)
+			if apply || (adjacent && p.AdjacentIsApplication) {
 				lo, e2, err = p.parseSingle(lo, true)
 				if err != nil {
 					return
 				}
-				e = &Apply{e, e2}
+				e = &Apply{e, e2, join(e, e2)}
 			} else {
 				break
 			}
@@ -252,11 +442,15 @@ func (p Parser) parseSingle(tokens []string, inApp bool) (lo []string, e Expr, e
 
 	// Look for an open parenthesis
 	for _, group := range p.Parens {
-		if tokens[0] == group.Left {
+		if tokens[0].text == group.Left {
 			lo, e, err = p.parseExpr(0, tokens[1:])
-			if lo[0] != group.Right {
-				return lo, nil, &Unexpected{lo[0], fmt.Sprintf("%#v", group.Right)}
+			if err != nil {
+				return
+			}
+			if lo[0].text != group.Right {
+				return lo, nil, unexpected(lo[0], fmt.Sprintf("%#v", group.Right))
 			}
+			setSpan(e, Span{tokens[0].span.Start, lo[0].span.End})
 			lo = lo[1:]
 			return
 		}
@@ -264,18 +458,23 @@ func (p Parser) parseSingle(tokens []string, inApp bool) (lo []string, e Expr, e
 
 	// Look for an open bracket
 	for _, group := range p.Brackets {
-		if tokens[0] == group.Left {
-			if len(tokens) > 1 && tokens[1] == group.Right {
+		if tokens[0].text == group.Left {
+			if len(tokens) > 1 && tokens[1].text == group.Right {
 				lo = tokens[2:]
-				e = &Var{group.Left + group.Right}
+				e = &Var{group.Left + group.Right,
+					Span{tokens[0].span.Start, tokens[1].span.End}}
 				return
 			}
 			lo, e, err = p.parseExpr(0, tokens[1:])
-			if lo[0] != group.Right {
-				return lo, nil, &Unexpected{lo[0], fmt.Sprintf("%#v", group.Right)}
+			if err != nil {
+				return
+			}
+			if lo[0].text != group.Right {
+				return lo, nil, unexpected(lo[0], fmt.Sprintf("%#v", group.Right))
 			}
+			e = &Unary{group.Left + group.Right, e,
+				Span{tokens[0].span.Start, lo[0].span.End}}
 			lo = lo[1:]
-			e = &Unary{group.Left + group.Right, e}
 			return
 		}
 	}
@@ -292,10 +491,10 @@ func (p Parser) parseSingle(tokens []string, inApp bool) (lo []string, e Expr, e
 	if options != "" {
 		options += "or a variable"
 	}
-	return tokens, nil, &Unexpected{tokens[0], options}
+	return tokens, nil, unexpected(tokens[0], options)
 }
 
-func (p Parser) parseExpr(prec int, tokens []string) (lo []string, e Expr, err error) {
+func (p Parser) parseExpr(prec int, tokens []token) (lo []token, e Expr, err error) {
 	if prec >= len(p.Operators) {
 		return p.parseSingle(tokens, false)
 	}
@@ -307,24 +506,27 @@ func (p Parser) parseExpr(prec int, tokens []string) (lo []string, e Expr, err e
 
 	switch op.Type {
 	case Prefix:
-		if glyph := lo[0]; isOp(glyph, op.Glyphs) {
+		if glyph := lo[0]; isOp(glyph.text, op.Glyphs) {
 			lo, e, err = p.parseExpr(prec, lo[1:])
 			if err != nil {
 				return lo, nil, err
 			}
-			return lo, &Unary{glyph, e}, nil
+			return lo, &Unary{glyph.text, e, Span{glyph.span.Start, spanOf(e).End}}, nil
 		}
 		return p.parseExpr(prec+1, tokens)
 
 	case InfixLeft:
 		lo, e, err = p.parseExpr(prec+1, lo)
-		for isOp(lo[0], op.Glyphs) {
-			glyph := lo[0]
+		if err != nil {
+			return lo, nil, err
+		}
+		for isOp(lo[0].text, op.Glyphs) {
+			glyph := lo[0].text
 			lo, e2, err = p.parseExpr(prec+1, lo[1:])
 			if err != nil {
 				return lo, nil, err
 			}
-			e = &Binary{glyph, e, e2}
+			e = &Binary{glyph, e, e2, join(e, e2)}
 		}
 		return
 
@@ -334,12 +536,12 @@ func (p Parser) parseExpr(prec int, tokens []string) (lo []string, e Expr, err e
 			return lo, nil, err
 		}
 
-		if glyph := lo[0]; isOp(glyph, op.Glyphs) {
+		if glyph := lo[0].text; isOp(glyph, op.Glyphs) {
 			lo, e2, err = p.parseExpr(prec, lo[1:])
 			if err != nil {
-				return lo[1:], nil, err
+				return lo, nil, err
 			}
-			e = &Binary{glyph, e, e2}
+			e = &Binary{glyph, e, e2, join(e, e2)}
 		}
 		return
 
@@ -349,24 +551,48 @@ func (p Parser) parseExpr(prec int, tokens []string) (lo []string, e Expr, err e
 			return lo, nil, err
 		}
 
-		for isOp(lo[0], op.Glyphs) {
-			e = &Unary{lo[0], e}
+		for isOp(lo[0].text, op.Glyphs) {
+			e = &Unary{lo[0].text, e, Span{spanOf(e).Start, lo[0].span.End}}
 			lo = lo[1:]
 		}
 		return
+
+	case Ternary:
+		lo, e, err = p.parseExpr(prec+1, lo)
+		if err != nil {
+			return lo, nil, err
+		}
+
+		if len(op.Glyphs) == 2 && lo[0].text == op.Glyphs[0] {
+			lo, e2, err = p.parseExpr(prec, lo[1:])
+			if err != nil {
+				return lo, nil, err
+			}
+			if lo[0].text != op.Glyphs[1] {
+				return lo, nil, unexpected(lo[0], fmt.Sprintf("%#v", op.Glyphs[1]))
+			}
+
+			var e3 Expr
+			lo, e3, err = p.parseExpr(prec, lo[1:])
+			if err != nil {
+				return lo, nil, err
+			}
+			e = &Cond{e, e2, e3, join(e, e3)}
+		}
+		return
 	}
 
 	panic("should not get here")
 }
 
-func (p Parser) parseEqn(tokens []string) (lo []string, r *Equation, err error) {
+func (p Parser) parseEqn(tokens []token) (lo []token, r *Equation, err error) {
 	lo, lhs, err := p.parseExpr(0, tokens)
 	if err != nil {
 		return
 	}
 
-	if lo[0] != "=" {
-		err = &Unexpected{lo[0], "="}
+	if lo[0].text != "=" {
+		err = unexpected(lo[0], "\"=\"")
 		return
 	}
 
@@ -375,7 +601,7 @@ func (p Parser) parseEqn(tokens []string) (lo []string, r *Equation, err error)
 		return
 	}
 
-	return lo, &Equation{lhs, rhs}, nil
+	return lo, &Equation{lhs, rhs, join(lhs, rhs)}, nil
 }
 
 // Parses an expression from source. On success, Expr is an expression; iff not,
@@ -383,27 +609,63 @@ func (p Parser) parseEqn(tokens []string) (lo []string, r *Equation, err error)
 func (p Parser) ParseExpr(source string) (Expr, error) {
 	tokens, err := p.tokenize(source)
 	if err != nil {
-		return nil, err
+		return nil, withSource(err, source)
 	}
 	lo, e, err := p.parseExpr(0, tokens)
 	if err != nil {
-		return nil, err
-	} else if len(lo) > 1 || lo[0] != "" {
-		return nil, &Unexpected{lo[0], "end-of-input"}
+		return nil, withSource(err, source)
+	} else if !isEof(lo[0].text) {
+		return nil, withSource(unexpected(lo[0], "end-of-input"), source)
 	}
 	return e, nil
 }
 
+func isSeparator(s string) bool {
+	return s == ";" || s == "\n"
+}
+
+// Parses a program of several equations, separated by semicolons or new
+// lines, as in "t = A' * b; x = inv(A' * A) * t". Blank statements are
+// ignored. On failure, error is non-nil and of type Unexpected{}.
+func (p Parser) ParseProgram(source string) ([]*Equation, error) {
+	tokens, err := p.tokenize(source)
+	if err != nil {
+		return nil, withSource(err, source)
+	}
+
+	program := []*Equation{}
+	for lo := tokens; ; {
+		for isSeparator(lo[0].text) {
+			lo = lo[1:]
+		}
+		if isEof(lo[0].text) {
+			return program, nil
+		}
+
+		var e *Equation
+		lo, e, err = p.parseEqn(lo)
+		if err != nil {
+			return nil, withSource(err, source)
+		} else if !isSeparator(lo[0].text) && !isEof(lo[0].text) {
+			return nil, withSource(unexpected(lo[0],
+				"\";\", a new line, or end-of-input"), source)
+		}
+		program = append(program, e)
+	}
+}
+
 // Parses a single equation in the given source. On success, Equation is a
 // parsed Equation; iff not, error is non-nil and of type Unexpected{}.
 func (p Parser) Parse(source string) (*Equation, error) {
 	tokens, err := p.tokenize(source)
 	if err != nil {
-		return nil, err
+		return nil, withSource(err, source)
 	}
 	lo, e, err := p.parseEqn(tokens)
-	if (len(lo) > 1 || lo[0] != "") && err == nil {
-		return nil, &Unexpected{lo[0], "end-of-input"}
+	if err != nil {
+		return nil, withSource(err, source)
+	} else if !isEof(lo[0].text) {
+		return nil, withSource(unexpected(lo[0], "end-of-input"), source)
 	}
-	return e, err
+	return e, nil
 }
@@ -1,11 +1,29 @@
 package mast_test
 
 import (
+	"fmt"
 	. "github.com/fatlotus/mast"
 	"strings"
 	"testing"
 )
 
+// A MATLAB-like language with multi-character operators.
+var matlab = Parser{
+	Parens: []Group{
+		{"(", ")"},
+	},
+	Operators: []Prec{
+		{[]string{":="}, InfixRight},
+		{[]string{"->"}, InfixRight},
+		{[]string{"==", "!=", "<", "<=", ">", ">="}, InfixLeft},
+		{[]string{"+", "-"}, InfixLeft},
+		{[]string{"*", "/", ".*", "./"}, InfixLeft},
+		{[]string{"**"}, InfixRight},
+		{[]string{"-"}, Prefix},
+		{[]string{"'", ".'"}, Suffix},
+	},
+}
+
 var succeed = []struct {
 	Parser Parser
 	Source string
@@ -31,6 +49,29 @@ var succeed = []struct {
 	{PEMDAS, "x = log a b c", "x = (((log a) b) c)"},
 	{PEMDAS, "q, r = qr c", "(q , r) = (qr c)"},
 	{PEMDAS, "x = 42", "x = 42"},
+	{PEMDAS, "x = 3.14 * r", "x = (3.14 * r)"},
+	{PEMDAS, "x = .5 + 1e-9 - 2.5E+3", "x = ((.5 + 1e-9) - 2.5E+3)"},
+	{PEMDAS, "x = 0x1F", "x = 0x1F"},
+	{PEMDAS, "x = 2e", "x = (2 e)"},
+	{PEMDAS, "x = 2x1", "x = ((2 x) 1)"},
+	{PEMDAS, "y = w .* x + b", "y = ((w .* x) + b)"},
+	{PEMDAS, "y = 2.*x./z.^2", "y = ((2 .* x) ./ (z .^ 2))"},
+	{PEMDAS, "y = A.' * b'", "y = ((.' A) * (' b))"},
+	{PEMDAS, "y = a + b % p * c", "y = (a + ((b % p) * c))"},
+	{PEMDAS, "y = x > 0 ? x : 0", "y = ((x > 0) ? x : 0)"},
+	{PEMDAS, "y = a ? b : c ? d : e", "y = (a ? b : (c ? d : e))"},
+	{PEMDAS, "y = (a ? b : c) ? d : e", "y = ((a ? b : c) ? d : e)"},
+	{PEMDAS, "y = a ? b ? c : d : e", "y = (a ? (b ? c : d) : e)"},
+	{PEMDAS, "y = a < b || !c && d >= -e", "y = ((a < b) || ((! c) && (d >= (- e))))"},
+	{PEMDAS, "y = a + 1 == b != c <= d", "y = ((((a + 1) == b) != c) <= d)"},
+	{PEMDAS, "y = where(x > 0, x, 0)", "y = (where (((x > 0) , x) , 0))"},
+	{matlab, "y = w .* x + b", "y = ((w .* x) + b)"},
+	{matlab, "y = 2.*x./3", "y = ((2 .* x) ./ 3)"},
+	{matlab, "y = a<=b == c!=d", "y = (((a <= b) == c) != d)"},
+	{matlab, "y = a < -b", "y = (a < (- b))"},
+	{matlab, "y = x**2**-1", "y = (x ** (2 ** (- 1)))"},
+	{matlab, "y = f -> a := b", "y = ((f -> a) := b)"},
+	{matlab, "y = A.'*A'", "y = ((.' A) * (' A))"},
 	{PEMDAS, "x = {}", "x = {}"},
 	{PEMDAS, "x = {a}", "x = ({} a)"},
 	{PEMDAS, "x = {a, b, c}", "x = ({} ((a , b) , c))"},
@@ -67,3 +108,123 @@ func TestParseExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestSpan(t *testing.T) {
+	tree, err := PEMDAS.Parse("y = (a + b) *  inv(C')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rhs := tree.Right.(*Binary)
+	spans := []struct {
+		Span Span
+		Want string
+	}{
+		{tree.Span, "1:1-1:23"},
+		{tree.Left.(*Var).Span, "1:1-1:2"},
+		{rhs.Span, "1:5-1:23"},
+		{rhs.Left.(*Binary).Span, "1:5-1:12"},
+		{rhs.Right.(*Apply).Span, "1:16-1:23"},
+		{rhs.Right.(*Apply).Operand.(*Unary).Span, "1:19-1:23"},
+	}
+	for _, s := range spans {
+		got := fmt.Sprintf("%s-%s", s.Span.Start, s.Span.End)
+		if got != s.Want {
+			t.Errorf("got span %s, expecting %s", got, s.Want)
+		}
+	}
+}
+
+func TestUnexpected(t *testing.T) {
+	_, err := PEMDAS.Parse("y = a +\tb * * c\nz = 1")
+	u, ok := err.(*Unexpected)
+	if !ok {
+		t.Fatalf("expected *Unexpected, got %#v", err)
+	}
+	if u.Found != "*" || u.Pos != (Pos{Offset: 12, Line: 1, Column: 13}) {
+		t.Errorf("got %q at %#v", u.Found, u.Pos)
+	}
+
+	want := "unexpected \"*\", expecting \"-\", \"+\", \"!\", or a variable at 1:13\n" +
+		"y = a +\tb * * c\n" +
+		"       \t    ^"
+	if err.Error() != want {
+		t.Errorf("got error\n%s\nexpecting\n%s", err, want)
+	}
+}
+
+func TestCond(t *testing.T) {
+	_, err := PEMDAS.Parse("y = x > 0 ? x")
+	if u, ok := err.(*Unexpected); !ok || u.Found != "" || u.Expecting != "\":\"" {
+		t.Errorf("got %v, expecting an unexpected end-of-input", err)
+	}
+
+	tree, err := PEMDAS.Parse("y = a ? b : c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := tree.Right.(*Cond); c.Span.Start.Column != 5 || c.Span.End.Column != 14 {
+		t.Errorf("got span %s-%s, expecting 1:5-1:14", c.Span.Start, c.Span.End)
+	}
+}
+
+func TestNum(t *testing.T) {
+	for source, value := range map[string]float64{
+		"42":     42,
+		"3.14":   3.14,
+		".5":     0.5,
+		"1e-9":   1e-9,
+		"2.5E+3": 2500,
+		"0x1F":   31,
+	} {
+		e, err := PEMDAS.ParseExpr(source)
+		if err != nil {
+			t.Errorf("%s, while parsing %#v", err, source)
+			continue
+		}
+		if n, ok := e.(*Num); !ok || n.Value != value || n.Text != source {
+			t.Errorf("parsing %s: got %#v, expecting %v", source, e, value)
+		}
+	}
+}
+
+func TestParseProgram(t *testing.T) {
+	program, err := PEMDAS.ParseProgram("t = A' * b; x = inv(A' * A) * t\n\nr = b - A x;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reps := []string{
+		"t = ((' A) * b)",
+		"x = ((inv ((' A) * A)) * t)",
+		"r = (b - (A x))",
+	}
+	if len(program) != len(reps) {
+		t.Fatalf("got %d equations, expecting %d", len(program), len(reps))
+	}
+	for i, e := range program {
+		if e.String() != reps[i] {
+			t.Errorf("got %#v, expecting %#v", e.String(), reps[i])
+		}
+	}
+	if start := program[2].Span.Start; start.Line != 3 || start.Column != 1 {
+		t.Errorf("third equation starts at %s, expecting 3:1", start)
+	}
+
+	_, err = PEMDAS.ParseProgram("x = a\ny = b c )")
+	if u, ok := err.(*Unexpected); !ok || u.Found != ")" || u.Pos.Line != 2 {
+		t.Errorf("got %v, expecting an unexpected \")\" on line 2", err)
+	}
+
+	// Windows line endings separate equations too
+	program, err = PEMDAS.ParseProgram("t = A' * b\r\nx = inv(A' * A) * t\r\n")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(program) != 2 {
+		t.Errorf("got %d equations, expecting 2", len(program))
+	}
+	_, err = PEMDAS.ParseProgram("x = a\r\ny = b c )\r\n")
+	if u, ok := err.(*Unexpected); !ok || u.Pos.Line != 2 || strings.Contains(err.Error(), "\r") {
+		t.Errorf("got %q, expecting an unexpected \")\" on line 2", err)
+	}
+}
@@ -0,0 +1,240 @@
+package mast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Program is a parsed list of equations, ready to be evaluated many times
+// without being parsed again. A Program is safe for concurrent use by
+// multiple goroutines.
+type Program struct {
+	source     string
+	statements []*Equation
+	vars       []*Var
+	env        *Env
+
+	// the variables assigned by each statement and by the last, the names
+	// of every variable assigned, and the names of those read before being
+	// assigned
+	assigns  [][]*Var
+	outputs  []*Var
+	assigned map[string]bool
+	reads    map[string]bool
+}
+
+// Parses the given equations into a Program that uses the built-in functions.
+func Compile(code string) (*Program, error) {
+	return defaultEnv.Compile(code)
+}
+
+// Like Compile, but panics if the equations cannot be parsed. This simplifies
+// initializing global variables holding compiled formulas.
+func MustCompile(code string) *Program {
+	prog, err := Compile(code)
+	if err != nil {
+		panic(err)
+	}
+	return prog
+}
+
+// Parses the given equations into a Program that uses the functions currently
+// registered in this Env. Functions registered afterwards are not seen by the
+// Program.
+//
+// Several equations may be given, separated by semicolons or new lines. They
+// are evaluated in order, and each may use the variables assigned by those
+// before it as temporaries. Only the variables assigned by the last equation
+// are passed in as arguments, as in "t = A' * b; x = inv(A' * A) * t".
+func (env *Env) Compile(code string) (*Program, error) {
+	statements, err := PEMDAS.ParseProgram(code)
+	if err != nil {
+		return nil, err
+	}
+	return env.compile(code, statements)
+}
+
+// Turns equations that have already been parsed, as by PEMDAS.Parse, into a
+// Program, as in Compile. This lets the same tree be evaluated by several
+// Envs, such as one using Float64 and another using BigRat.
+func (env *Env) CompileEquations(statements ...*Equation) (*Program, error) {
+	source := []string{}
+	for _, tree := range statements {
+		source = append(source, tree.String())
+	}
+	return env.compile(strings.Join(source, "; "), append([]*Equation{}, statements...))
+}
+
+func (env *Env) compile(code string, statements []*Equation) (*Program, error) {
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("program %#v has no equations", code)
+	}
+
+	// take a snapshot, so that later calls to RegisterFunc can't race with Run
+	snapshot := env.clone()
+
+	inputs := []*Var{}
+	assigns := [][]*Var{}
+	outputs := []*Var{}
+	assigned := map[string]bool{}
+	reads := map[string]bool{}
+
+	for _, tree := range statements {
+		used := []*Var{}
+		snapshot.addVars(tree.Right, &used)
+		for _, v := range used {
+			if _, ok := snapshot.constant(v.Name); ok && !assigned[v.Name] {
+				continue
+			}
+			if !assigned[v.Name] && !reads[v.Name] {
+				reads[v.Name] = true
+				inputs = append(inputs, v)
+			}
+		}
+
+		var err error
+		if outputs, err = assignees(tree); err != nil {
+			return nil, err
+		}
+		assigns = append(assigns, outputs)
+		for _, v := range outputs {
+			assigned[v.Name] = true
+		}
+	}
+
+	vars := append([]*Var{}, outputs...)
+	for _, v := range inputs {
+		snapshot.addVars(v, &vars)
+	}
+
+	return &Program{code, statements, vars, snapshot, assigns, outputs, assigned, reads}, nil
+}
+
+// Returns the variables assigned by the given equation.
+func assignees(tree *Equation) ([]*Var, error) {
+	outputs := []*Var{}
+	for _, e := range arguments(tree.Left) {
+		v, ok := e.(*Var)
+		if !ok {
+			return nil, fmt.Errorf(
+				"expression was %s, but must be of the form \"y = ...\" or \"a, b = ...\"", tree)
+		}
+		for _, prev := range outputs {
+			if prev.Name == v.Name {
+				return nil, fmt.Errorf("variable %#v is assigned twice, in %s", v.Name, tree)
+			}
+		}
+		outputs = append(outputs, v)
+	}
+	return outputs, nil
+}
+
+// Returns the names of the variables in this Program, in the order that Run
+// expects them: the variables assigned by the last equation, then each
+// variable read before being assigned, in order of first usage. If a variable
+// being assigned is also read, as in "x = x + A*d", it is listed only once,
+// and its value is read before being overwritten with the result.
+func (p *Program) Vars() []string {
+	names := []string{}
+	for _, v := range p.vars {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// Returns the source code this Program was compiled from.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Evaluates this Program with the given variables, assigned in the order
+// given by Vars.
+func (p *Program) Run(args ...interface{}) error {
+	if len(p.vars) != len(args) {
+		return fmt.Errorf("got %#v args, hoping for %d (to make %v)",
+			len(args), len(p.vars), p.Vars())
+	}
+	return p.run(args, nil)
+}
+
+// Evaluates this Program with variables bound by name. Every variable listed
+// by Vars must appear in vars. Temporaries may appear as well, in which case
+// their final values are stored; any other name is an error.
+func (p *Program) RunMap(vars map[string]interface{}) error {
+	args := []interface{}{}
+	for _, v := range p.vars {
+		arg, ok := vars[v.Name]
+		if !ok {
+			return &UndefinedVariableError{v, v.Name}
+		}
+		args = append(args, arg)
+	}
+
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	extras := map[string]interface{}{}
+	for _, name := range names {
+		used := false
+		for _, v := range p.vars {
+			used = used || v.Name == name
+		}
+		if used {
+			continue
+		} else if !p.assigned[name] {
+			return &UnusedVariableError{name}
+		}
+		extras[name] = vars[name]
+	}
+
+	return p.run(args, extras)
+}
+
+// Evaluates each equation in turn, then stores the results in args and in
+// the named extras.
+func (p *Program) run(args []interface{}, extras map[string]interface{}) error {
+	var err error
+	scope := map[string]Value{}
+	for i, v := range p.vars {
+		if !p.reads[v.Name] {
+			continue
+		}
+		if scope[v.Name], err = p.env.algebra.Read(args[i]); err != nil {
+			return at(err, v)
+		}
+	}
+
+	for i, tree := range p.statements {
+		outputs := p.assigns[i]
+		results, err := p.env.evalTuple(tree.Right, len(outputs), scope)
+		if err != nil {
+			return err
+		}
+		for i, v := range outputs {
+			scope[v.Name] = results[i]
+		}
+	}
+
+	for i, v := range p.outputs {
+		if err := p.env.algebra.Write(args[i], scope[v.Name]); err != nil {
+			return at(err, v)
+		}
+	}
+	for name, arg := range extras {
+		if err := p.env.algebra.Write(arg, scope[name]); err != nil {
+			return at(err, &Var{Name: name})
+		}
+	}
+	return nil
+}
+
+// Like Run, but panics if something goes wrong.
+func (p *Program) MustRun(args ...interface{}) {
+	if err := p.Run(args...); err != nil {
+		panic(err)
+	}
+}
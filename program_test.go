@@ -0,0 +1,156 @@
+package mast_test
+
+import (
+	"fmt"
+	. "github.com/fatlotus/mast"
+	"sync"
+	"testing"
+)
+
+func TestProgram(t *testing.T) {
+	prog := MustCompile("y = A * x + b")
+	if vars := fmt.Sprint(prog.Vars()); vars != "[y A x b]" {
+		t.Errorf("got vars %s, expecting [y A x b]", vars)
+	}
+
+	A := [][]float64{{1, 2}, {3, 4}}
+	b := []float64{7, 8}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			y, x := []float64{0, 0}, []float64{float64(i), 1}
+			for j := 0; j < 100; j++ {
+				if err := prog.Run(&y, &A, &x, &b); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if y[0] != float64(i)+9 || y[1] != 3*float64(i)+12 {
+				t.Errorf("got y = %v for x = %v", y, x)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCompileSnapshot(t *testing.T) {
+	env := NewEnv()
+	prog, err := env.Compile("y = f(x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.RegisterFunc("f", func(args ...Matrix) (Matrix, error) {
+		return NewMatrix(1, 1, []float64{42}), nil
+	})
+
+	// f was not a function when compiled, so it is still a variable
+	if vars := fmt.Sprint(prog.Vars()); vars != "[y f x]" {
+		t.Errorf("got vars %s, expecting [y f x]", vars)
+	}
+
+	if _, err := Compile("y = (x"); err == nil {
+		t.Errorf("expected a parse error")
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	prog := MustCompile("y = A * x + c")
+	A := [][]float64{{1, 2}, {3, 4}}
+	y, x, c := []float64{0, 0}, []float64{5, 6}, []float64{7, 8}
+	for i := 0; i < b.N; i++ {
+		prog.MustRun(&y, &A, &x, &c)
+	}
+}
+
+func TestRunMap(t *testing.T) {
+	A := [][]float64{{1, 2}, {3, 4}}
+	y, x, b := []float64{0, 0}, []float64{5, 6}, []float64{7, 8}
+
+	// the order of the terms doesn't matter when binding by name
+	for _, source := range []string{"y = A * x + b", "y = b + A * x"} {
+		err := EvalMap(source, map[string]interface{}{
+			"y": &y, "A": &A, "x": &x, "b": &b,
+		})
+		if err != nil {
+			t.Errorf("%s, while evaluating %s", err, source)
+		} else if y[0] != 24 || y[1] != 47 {
+			t.Errorf("evaluating %s: got y = %v", source, y)
+		}
+	}
+
+	err := EvalMap("y = A * x + b", map[string]interface{}{
+		"y": &y, "A": &A, "x": &x,
+	})
+	if u, ok := err.(*UndefinedVariableError); !ok || u.Name != "b" {
+		t.Errorf("expected b to be undefined, got %v", err)
+	}
+
+	err = EvalMap("y = A * x", map[string]interface{}{
+		"y": &y, "A": &A, "x": &x, "b": &b, "c": &b,
+	})
+	if u, ok := err.(*UnusedVariableError); !ok || u.Name != "b" {
+		t.Errorf("expected b to be unused, got %v", err)
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	prog := MustCompile("x = x + A * d")
+	if vars := fmt.Sprint(prog.Vars()); vars != "[x A d]" {
+		t.Errorf("got vars %s, expecting [x A d]", vars)
+	}
+
+	x := []float64{1, 1}
+	A := [][]float64{{0.5, 0}, {0, 0.25}}
+	d := []float64{2, 4}
+	for i := 0; i < 3; i++ {
+		prog.MustRun(&x, &A, &d)
+	}
+	if x[0] != 4 || x[1] != 4 {
+		t.Errorf("got x = %v, expecting [4 4]", x)
+	}
+
+	// the assigned variable is read even if it isn't the first on the right
+	s, k := 3.0, 2.0
+	MustEval("s = k * s", &s, &k)
+	if s != 6 {
+		t.Errorf("got s = %v, expecting 6", s)
+	}
+}
+
+func TestMultipleStatements(t *testing.T) {
+	prog := MustCompile("t = A' * b; x = inv(A' * A) * t\nr = b - A * x")
+	if vars := fmt.Sprint(prog.Vars()); vars != "[r A b]" {
+		t.Errorf("got vars %s, expecting [r A b]", vars)
+	}
+
+	A := [][]float64{{1, 0}, {0, 1}, {1, 1}}
+	b := []float64{1, 2, 4}
+	r := []float64{0, 0, 0}
+	prog.MustRun(&r, &A, &b)
+	if !closeTo([][]float64{r}, [][]float64{{-1.0 / 3, -1.0 / 3, 1.0 / 3}}) {
+		t.Errorf("got r = %v", r)
+	}
+
+	// temporaries can be retrieved by name
+	x := []float64{0, 0}
+	err := prog.RunMap(map[string]interface{}{"r": &r, "x": &x, "A": &A, "b": &b})
+	if err != nil {
+		t.Error(err)
+	} else if !closeTo([][]float64{x}, [][]float64{{4.0 / 3, 7.0 / 3}}) {
+		t.Errorf("got x = %v", x)
+	}
+
+	// a variable read before it is assigned is an input
+	y, k := 1.0, 3.0
+	MustEval("k = k + 1; y = y * k", &y, &k)
+	if y != 4 {
+		t.Errorf("got y = %v, expecting 4", y)
+	}
+
+	if _, err := Compile("; \n"); err == nil {
+		t.Errorf("expected an error compiling an empty program")
+	}
+}
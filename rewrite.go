@@ -0,0 +1,237 @@
+package mast
+
+import (
+	"fmt"
+)
+
+// Bindings map the metavariables of a pattern, without their "?", to the
+// expressions they matched.
+type Bindings map[string]Expr
+
+// A Rule rewrites expressions matching Pattern into Replacement, with each
+// metavariable of the Replacement standing for whatever it matched in the
+// Pattern. Rules are usually written as "pattern -> replacement" and parsed
+// with ParseRule.
+type Rule struct {
+	Pattern     Expr
+	Replacement Expr
+}
+
+// Represent this Rule as a string.
+func (r Rule) String() string {
+	return fmt.Sprintf("%s -> %s", r.Pattern, r.Replacement)
+}
+
+// Parses an expression in which metavariables such as "?a" may appear, as in
+// "?a * (?b + ?c)". Each metavariable is a Var whose Name begins with "?",
+// and matches any expression in Match. Note that a ternary operator written
+// "?" must be followed by a space, as in "?t ? ?a : ?b", to tell it apart.
+func (p Parser) ParsePattern(source string) (Expr, error) {
+	p.patterns = true
+	return p.ParseExpr(source)
+}
+
+// Parses a Rule of the form "pattern -> replacement", as in
+// "?a * (?b + ?c) -> ?a * ?b + ?a * ?c". Every metavariable of the
+// replacement must appear in the pattern. On failure, error is non-nil and
+// of type Unexpected{}.
+func (p Parser) ParseRule(source string) (Rule, error) {
+	p.patterns = true
+	tokens, err := p.tokenize(source)
+	if err != nil {
+		return Rule{}, withSource(err, source)
+	}
+
+	lo, pattern, err := p.parseExpr(0, tokens)
+	if err != nil {
+		return Rule{}, withSource(err, source)
+	} else if lo[0].text != "->" {
+		return Rule{}, withSource(unexpected(lo[0], "\"->\""), source)
+	}
+	lo, replacement, err := p.parseExpr(0, lo[1:])
+	if err != nil {
+		return Rule{}, withSource(err, source)
+	} else if !isEof(lo[0].text) {
+		return Rule{}, withSource(unexpected(lo[0], "end-of-input"), source)
+	}
+
+	bound := metavariables(pattern, nil)
+	for _, v := range metavariables(replacement, nil) {
+		found := false
+		for _, b := range bound {
+			found = found || b.Name == v.Name
+		}
+		if !found {
+			return Rule{}, &Unexpected{Found: v.Name, Pos: v.Span.Start, Source: source,
+				Expecting: "a metavariable of the pattern"}
+		}
+	}
+	return Rule{pattern, replacement}, nil
+}
+
+// Returns the metavariables in e, appended to vars.
+func metavariables(e Expr, vars []*Var) []*Var {
+	Walk(e, func(e Expr) bool {
+		if v, ok := e.(*Var); ok && isMeta(v.Name) {
+			vars = append(vars, v)
+		}
+		return true
+	})
+	return vars
+}
+
+// Reports whether e has the same shape as pattern, where each metavariable
+// of the pattern matches any expression; if it appears more than once, the
+// expressions it matches must be Equal. Matching is structural, so that
+// "?a + ?b" does not match "b - a"; Simplify both first to match up to
+// reordering.
+func Match(pattern, e Expr) (Bindings, bool) {
+	b := Bindings{}
+	if !match(pattern, e, b) {
+		return nil, false
+	}
+	return b, true
+}
+
+func match(pattern, e Expr, b Bindings) bool {
+	switch p := pattern.(type) {
+	case *Var:
+		if !isMeta(p.Name) {
+			v, ok := e.(*Var)
+			return ok && v.Name == p.Name
+		} else if bound, ok := b[p.Name[1:]]; ok {
+			return Equal(bound, e)
+		}
+		b[p.Name[1:]] = e
+		return true
+	case *Num:
+		n, ok := e.(*Num)
+		return ok && n.Value == p.Value
+	case *Unary:
+		u, ok := e.(*Unary)
+		return ok && u.Op == p.Op && match(p.Elem, u.Elem, b)
+	case *Binary:
+		o, ok := e.(*Binary)
+		return ok && o.Op == p.Op && match(p.Left, o.Left, b) && match(p.Right, o.Right, b)
+	case *Apply:
+		a, ok := e.(*Apply)
+		return ok && match(p.Operator, a.Operator, b) && match(p.Operand, a.Operand, b)
+	case *Cond:
+		c, ok := e.(*Cond)
+		return ok && match(p.Test, c.Test, b) && match(p.Then, c.Then, b) &&
+			match(p.Else, c.Else, b)
+	case *Equation:
+		q, ok := e.(*Equation)
+		return ok && match(p.Left, q.Left, b) && match(p.Right, q.Right, b)
+	}
+	return false
+}
+
+// Returns a copy of e with each metavariable replaced by its binding.
+// Metavariables without one are left as they are.
+func (b Bindings) Substitute(e Expr) Expr {
+	return Transform(e, func(e Expr) Expr {
+		if v, ok := e.(*Var); ok && isMeta(v.Name) && b[v.Name[1:]] != nil {
+			return b[v.Name[1:]]
+		}
+		return e
+	})
+}
+
+// The most rules Rewrite will apply, the most nodes the expression may grow
+// to, and the most nodes Rewrite will visit over all of its passes, before
+// giving up.
+const (
+	maxRewrites = 10000
+	maxSize     = 100000
+	maxVisits   = 10 * maxSize
+)
+
+// A RewriteError indicates that rewriting the expression at Span did not
+// reach a fixed point, such as with the rule "?a + ?b -> ?b + ?a", which
+// applies forever, or "?a -> ?a + 0", which grows the expression forever.
+// Only the Span is kept, since the expression may be very large.
+type RewriteError struct {
+	Span   Span
+	Reason string
+}
+
+// Represent this RewriteError as a string.
+func (r RewriteError) Error() string {
+	if start := r.Span.Start; start.Line > 0 {
+		return fmt.Sprintf("%s, in the expression at %s", r.Reason, start)
+	}
+	return r.Reason
+}
+
+// Applies the rules to e until none match anywhere in it. Each pass rewrites
+// the innermost expressions first, using the first matching rule for each.
+// The result is a new Expr, leaving e unchanged. If the rules apply too many
+// times, take too many passes over the expression, or grow it too large,
+// Rewrite gives up with a *RewriteError.
+func Rewrite(e Expr, rules []Rule) (Expr, error) {
+	r := &rewriter{rules: rules}
+	for result := e; ; {
+		next, changed := r.rewrite(result)
+		if r.reason != "" {
+			return nil, &RewriteError{spanOf(e), r.reason}
+		} else if !changed {
+			return next, nil
+		}
+		result = next
+	}
+}
+
+// A rewriter keeps count of the work done by Rewrite.
+type rewriter struct {
+	rules  []Rule
+	count  int
+	visits int
+	sizes  map[Expr]int
+	reason string
+}
+
+// Rewrites each sub-expression of e once, reporting whether any rule applied.
+// Once a limit is reached, the reason is recorded and nothing more changes.
+func (r *rewriter) rewrite(e Expr) (Expr, bool) {
+	changed := false
+	r.sizes = map[Expr]int{}
+	e = Transform(e, func(e Expr) Expr {
+		if r.reason != "" {
+			return e
+		}
+		r.visits++
+		for _, rule := range r.rules {
+			if b, ok := Match(rule.Pattern, e); ok {
+				changed = true
+				r.count++
+				e = b.Substitute(rule.Replacement)
+				break
+			}
+		}
+		if r.count > maxRewrites {
+			r.reason = fmt.Sprintf("no fixed point after %d rewrites", maxRewrites)
+		} else if r.visits > maxVisits {
+			r.reason = fmt.Sprintf("no fixed point after visiting %d nodes", maxVisits)
+		} else if r.size(e) > maxSize {
+			r.reason = fmt.Sprintf("rewriting grew past %d nodes", maxSize)
+		}
+		return e
+	})
+	return e, changed
+}
+
+// Returns the number of nodes in e. Substitute shares subtrees, so sizes are
+// remembered rather than counted again along every path to them, for the
+// length of one pass.
+func (r *rewriter) size(e Expr) int {
+	if n, ok := r.sizes[e]; ok {
+		return n
+	}
+	n := 1
+	for _, child := range children(e) {
+		n += r.size(child)
+	}
+	r.sizes[e] = n
+	return n
+}
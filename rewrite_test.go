@@ -0,0 +1,183 @@
+package mast_test
+
+import (
+	. "github.com/fatlotus/mast"
+	"strings"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		Source string
+		Rep    string
+	}{
+		{"?a * (?b + ?c)", "(?a * (?b + ?c))"},
+		{"?f ?x", "(?f ?x)"},
+		{"sin(?x)^2", "((sin ?x) ^ 2)"},
+		{"?A' * ?B", "((' ?A) * ?B)"},
+		{"?t ? ?a : ?b", "(?t ? ?a : ?b)"},
+	}
+	for _, test := range tests {
+		e, err := PEMDAS.ParsePattern(test.Source)
+		if err != nil {
+			t.Errorf("%s, while parsing %#v", err, test.Source)
+		} else if e.String() != test.Rep {
+			t.Errorf("parsing %s\ngot       %s\nexpecting %s", test.Source, e, test.Rep)
+		}
+	}
+
+	if _, err := PEMDAS.ParseExpr("?a + b"); err == nil {
+		t.Errorf("expected metavariables to be rejected outside patterns")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Source  string
+		Match   bool
+		Bound   map[string]string
+	}{
+		{"?a * (?b + ?c)", "x * (y + 2)", true,
+			map[string]string{"a": "x", "b": "y", "c": "2"}},
+		{"?a * (?b + ?c)", "(p - q) * (r + s')", true,
+			map[string]string{"a": "(p - q)", "b": "r", "c": "(' s)"}},
+		{"?a - ?a", "sin(x) - sin(x)", true, map[string]string{"a": "(sin x)"}},
+		{"?a - ?a", "sin(x) - sin(y)", false, nil},
+		{"?a + ?b", "b - a", false, nil},
+		{"sin(?x)", "cos(x)", false, nil},
+		{"?x * 2", "y * 0x2", true, map[string]string{"x": "y"}},
+		{"?t ? ?a : 0", "x > 0 ? x : 0", true,
+			map[string]string{"t": "(x > 0)", "a": "x"}},
+	}
+
+	for _, test := range tests {
+		pattern, err := PEMDAS.ParsePattern(test.Pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, ok := Match(pattern, mustParse(t, test.Source))
+		if ok != test.Match {
+			t.Errorf("matching %s against %s: got %v, expecting %v",
+				test.Pattern, test.Source, ok, test.Match)
+			continue
+		}
+		if len(b) != len(test.Bound) {
+			t.Errorf("matching %s against %s: got bindings %v, expecting %v",
+				test.Pattern, test.Source, b, test.Bound)
+		}
+		for name, rep := range test.Bound {
+			if b[name] == nil || b[name].String() != rep {
+				t.Errorf("matching %s against %s: bound ?%s to %v, expecting %s",
+					test.Pattern, test.Source, name, b[name], rep)
+			}
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rules := []Rule{}
+	for _, source := range []string{
+		"?a * (?b + ?c) -> ?a * ?b + ?a * ?c",
+		"(?a + ?b) * ?c -> ?a * ?c + ?b * ?c",
+		"sin(?x)^2 + cos(?x)^2 -> 1",
+		"?a'' -> ?a",
+	} {
+		rule, err := PEMDAS.ParseRule(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+
+	tests := []struct {
+		Source string
+		Rep    string
+	}{
+		{"x * (y + z)", "((x * y) + (x * z))"},
+		{"(a + b) * (c + d)", "(((a * c) + (b * c)) + ((a * d) + (b * d)))"},
+		{"2 * (sin(t)^2 + cos(t)^2)", "(2 * 1)"},
+		{"y = A'' * x", "y = (A * x)"},
+		{"y = x", "y = x"},
+	}
+	for _, test := range tests {
+		var e Expr
+		if eqn, err := PEMDAS.Parse(test.Source); err == nil {
+			e = eqn
+		} else {
+			e = mustParse(t, test.Source)
+		}
+		before := e.String()
+
+		got, err := Rewrite(e, rules)
+		if err != nil {
+			t.Errorf("%s, while rewriting %s", err, test.Source)
+		} else if got.String() != test.Rep {
+			t.Errorf("rewriting %s\ngot       %s\nexpecting %s", test.Source, got, test.Rep)
+		}
+		if e.String() != before {
+			t.Errorf("rewriting %s changed it to %s", test.Source, e)
+		}
+	}
+
+	swap, err := PEMDAS.ParseRule("?a + ?b -> ?b + ?a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rewrite(mustParse(t, "x + y"), []Rule{swap}); err == nil {
+		t.Errorf("expected a rule that applies forever to fail")
+	} else if _, ok := err.(*RewriteError); !ok {
+		t.Errorf("got %v, expecting a *RewriteError", err)
+	}
+
+	grow, err := PEMDAS.ParseRule("?a -> ?a + 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rewrite(mustParse(t, "x"), []Rule{grow}); err == nil {
+		t.Errorf("expected a rule that grows forever to fail")
+	} else if _, ok := err.(*RewriteError); !ok {
+		t.Errorf("got %v, expecting a *RewriteError", err)
+	}
+
+	double, err := PEMDAS.ParseRule("f(?a) -> f(?a, ?a)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rewrite(mustParse(t, "f(x)"), []Rule{double}); err == nil {
+		t.Errorf("expected a rule that doubles forever to fail")
+	} else if err.Error() != "rewriting grew past 100000 nodes, in the expression at 1:1" {
+		t.Errorf("got %v, expecting the expression to grow too large", err)
+	}
+
+	// rules that cycle one rewrite at a time stop after a bounded number of
+	// passes over the expression
+	there, err := PEMDAS.ParseRule("f(0) -> f(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := PEMDAS.ParseRule("f(1) -> f(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := mustParse(t, "f(0)"+strings.Repeat(" + x", 4000))
+	if _, err := Rewrite(long, []Rule{there, back}); err == nil {
+		t.Errorf("expected rules that cycle forever to fail")
+	} else if err.Error() != "no fixed point after visiting 1000000 nodes, in the expression at 1:1" {
+		t.Errorf("got %v, expecting too many nodes to be visited", err)
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, source := range []string{
+		"?a + ?b",
+		"?a -> ?a ->",
+		"?a * ?b -> ?c",
+	} {
+		if _, err := PEMDAS.ParseRule(source); err == nil {
+			t.Errorf("parsing %s: expected an error", source)
+		} else if _, ok := err.(*Unexpected); !ok {
+			t.Errorf("parsing %s: got %v, expecting an *Unexpected", source, err)
+		}
+	}
+}
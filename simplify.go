@@ -0,0 +1,313 @@
+package mast
+
+import (
+	"math"
+	"sort"
+)
+
+// Returns a simplified copy of e, leaving e itself unchanged. Simplify
+// applies a fixed set of rules which, for finite values, hold for every
+// built-in Algebra and keep the size of every matrix:
+//
+//   - double negations, double transposes and "+" as a prefix cancel out,
+//     as in "--a" or "(a')'";
+//   - adding zero and multiplying or dividing by one do nothing, treating
+//     0 and 1 as scalars, as does raising to the power one elementwise;
+//     "A^1" is kept, since it fails unless A is square;
+//   - like terms are collected, as in "2*x + y - x" to "x + y", and
+//     integer literals are folded, as in "2 * 3 * x" to "6 * x";
+//   - a conditional with a literal test is replaced by its chosen branch,
+//     if the other branch is a literal or the same.
+//
+// Multiplying by zero only gives zero when every factor is a literal, so
+// "A - A" and "0 * A" both simplify to "0 * A", which is a matrix the size
+// of A, and NaN wherever A is.
+//
+// The result is in a canonical form, in which the terms of a sum, the
+// factors of an elementwise product, and the operands of "==", "!=", "&&"
+// and "||" appear in a fixed order, and "a > b" is written "b < a". So two
+// formulas that differ only in these ways simplify to Equal trees, as do
+// "a + b" and "b + a". The factors of "*" keep their order, except for
+// literals, since matrix multiplication does not commute.
+func Simplify(e Expr) Expr {
+	return Transform(e, func(e Expr) Expr {
+		switch e := e.(type) {
+		case *Unary:
+			return simplifyUnary(e.Op, e.Elem)
+		case *Binary:
+			return simplifyBinary(e.Op, e.Left, e.Right)
+		case *Cond:
+			// both branches are broadcast to the size of the result, so
+			// one can only stand alone if the other has no size of its own
+			if v, ok := literalValue(e.Test); ok {
+				chosen, other := e.Then, e.Else
+				if v == 0 {
+					chosen, other = e.Else, e.Then
+				}
+				if isLiteralFactor(other) || Equal(chosen, other) {
+					return chosen
+				}
+			}
+		}
+		return e
+	})
+}
+
+// Returns whether a and b are the same tree, ignoring the Span of each node
+// and how literals were written, so that "0x10" equals "16".
+func Equal(a, b Expr) bool {
+	switch a := a.(type) {
+	case *Var:
+		b, ok := b.(*Var)
+		return ok && a.Name == b.Name
+	case *Num:
+		b, ok := b.(*Num)
+		return ok && a.Value == b.Value
+	case *Unary:
+		b, ok := b.(*Unary)
+		return ok && a.Op == b.Op && Equal(a.Elem, b.Elem)
+	case *Binary:
+		b, ok := b.(*Binary)
+		return ok && a.Op == b.Op && Equal(a.Left, b.Left) && Equal(a.Right, b.Right)
+	case *Apply:
+		b, ok := b.(*Apply)
+		return ok && Equal(a.Operator, b.Operator) && Equal(a.Operand, b.Operand)
+	case *Cond:
+		b, ok := b.(*Cond)
+		return ok && Equal(a.Test, b.Test) && Equal(a.Then, b.Then) && Equal(a.Else, b.Else)
+	case *Equation:
+		b, ok := b.(*Equation)
+		return ok && Equal(a.Left, b.Left) && Equal(a.Right, b.Right)
+	default:
+		return a == b
+	}
+}
+
+// Returns whether v is an integer that float64 represents exactly, so that
+// folding it gives the same result in every Algebra.
+func isExact(v float64) bool {
+	return v == math.Trunc(v) && math.Abs(v) < 1<<53
+}
+
+// Returns the value of e, if it is an exact integer literal.
+func exactValue(e Expr) (float64, bool) {
+	v, ok := literalValue(e)
+	return v, ok && isExact(v)
+}
+
+func simplifyUnary(op string, x Expr) Expr {
+	switch op {
+	case "+":
+		return x
+	case "-":
+		return sum(terms(x, -1, nil))
+	case "'", ".'":
+		if _, ok := literalValue(x); ok {
+			return x
+		} else if u, ok := x.(*Unary); ok && u.Op == op {
+			return u.Elem
+		}
+	case "!":
+		if v, ok := literalValue(x); ok {
+			return literal(float64(boolToInt(v == 0)))
+		}
+	}
+	return &Unary{Op: op, Elem: x}
+}
+
+func simplifyBinary(op string, a, b Expr) Expr {
+	switch op {
+	case "+":
+		return sum(terms(b, 1, terms(a, 1, nil)))
+	case "-":
+		return sum(terms(b, -1, terms(a, 1, nil)))
+	case "*", ".*":
+		return product(op, []Expr{a, b})
+	case "/", "./":
+		if isLiteral(b, 1) {
+			return a
+		}
+	case "\\":
+		if isLiteral(a, 1) {
+			return b
+		}
+	case "^", ".^":
+		x, xok := exactValue(a)
+		y, yok := exactValue(b)
+		if isLiteral(b, 1) && (op == ".^" || isLiteralFactor(a)) {
+			return a
+		} else if xok && yok && y >= 0 && isExact(math.Pow(x, y)) {
+			return literal(math.Pow(x, y))
+		}
+	case "==", "!=", "&&", "||":
+		if b.String() < a.String() {
+			a, b = b, a
+		}
+	case ">":
+		return simplifyBinary("<", b, a)
+	case ">=":
+		return simplifyBinary("<=", b, a)
+	}
+	return &Binary{Op: op, Left: a, Right: b}
+}
+
+// A term of a sum, which is coef * e, or just coef if e is nil.
+type term struct {
+	coef float64
+	e    Expr
+}
+
+// Appends the terms of e, each multiplied by sign, to ts.
+func terms(e Expr, sign float64, ts []term) []term {
+	switch x := e.(type) {
+	case *Binary:
+		if x.Op == "+" {
+			return terms(x.Right, sign, terms(x.Left, sign, ts))
+		} else if x.Op == "-" {
+			return terms(x.Right, -sign, terms(x.Left, sign, ts))
+		} else if v, ok := exactValue(x.Left); ok && x.Op == "*" {
+			return append(ts, term{sign * v, x.Right})
+		}
+	case *Unary:
+		if x.Op == "-" {
+			return terms(x.Elem, -sign, ts)
+		}
+	}
+
+	if v, ok := exactValue(e); ok {
+		return append(ts, term{sign * v, nil})
+	}
+	return append(ts, term{sign, e})
+}
+
+// Returns coef * e, or coef alone if e is nil.
+func scaled(coef float64, e Expr) Expr {
+	switch {
+	case e == nil:
+		return literal(coef)
+	case coef == 1:
+		return e
+	case coef == -1:
+		return &Unary{Op: "-", Elem: e}
+	}
+	return &Binary{Op: "*", Left: literal(coef), Right: e}
+}
+
+// Collects like terms and rebuilds the sum in canonical order.
+func sum(ts []term) Expr {
+	merged := []term{}
+	for _, t := range ts {
+		found := false
+		for i, m := range merged {
+			same := (t.e == nil && m.e == nil) ||
+				(t.e != nil && m.e != nil && !isLiteralFactor(t.e) && Equal(t.e, m.e))
+			if same && isExact(m.coef+t.coef) {
+				merged[i].coef += t.coef
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, t)
+		}
+	}
+
+	// as in product, a term that cancels keeps its size as 0 * e
+	ts = []term{}
+	for _, t := range merged {
+		if t.coef != 0 {
+			ts = append(ts, t)
+		} else if t.e != nil {
+			ts = append(ts, term{0, t.e})
+		}
+	}
+	if len(ts) == 0 {
+		return literal(0)
+	}
+
+	// variables first in order, then constants, but leading with a term
+	// that is added rather than subtracted if there is one
+	sort.SliceStable(ts, func(i, j int) bool {
+		if (ts[i].e == nil) != (ts[j].e == nil) {
+			return ts[j].e == nil
+		}
+		return ts[i].e != nil && ts[i].e.String() < ts[j].e.String()
+	})
+	for i, t := range ts {
+		if t.coef > 0 {
+			ts = append([]term{t}, append(ts[:i:i], ts[i+1:]...)...)
+			break
+		}
+	}
+
+	result := scaled(ts[0].coef, ts[0].e)
+	for _, t := range ts[1:] {
+		if t.coef < 0 {
+			result = &Binary{Op: "-", Left: result, Right: scaled(-t.coef, t.e)}
+		} else {
+			result = &Binary{Op: "+", Left: result, Right: scaled(t.coef, t.e)}
+		}
+	}
+	return result
+}
+
+// Appends the factors of the product e with operator op to fs, collecting
+// literal integers and negations into coef.
+func factors(op string, e Expr, coef *float64, fs []Expr) []Expr {
+	switch x := e.(type) {
+	case *Binary:
+		if x.Op == op || x.Op == "*" && isLiteralFactor(x.Left) {
+			return factors(op, x.Right, coef, factors(op, x.Left, coef, fs))
+		}
+	case *Unary:
+		if x.Op == "-" {
+			*coef = -*coef
+			return factors(op, x.Elem, coef, fs)
+		}
+	}
+
+	if v, ok := exactValue(e); ok && isExact(*coef*v) {
+		*coef *= v
+		return fs
+	}
+	return append(fs, e)
+}
+
+// Returns whether e is a literal, which commutes with every factor.
+func isLiteralFactor(e Expr) bool {
+	_, ok := literalValue(e)
+	return ok
+}
+
+// Multiplies operands with op, which is "*" or ".*", in canonical order.
+func product(op string, operands []Expr) Expr {
+	coef := 1.0
+	fs := []Expr{}
+	for _, operand := range operands {
+		fs = factors(op, operand, &coef, fs)
+	}
+	if coef == 0 {
+		coef = 0 // rather than -0, keeping the factors for their size
+	}
+
+	// literals commute with everything, but matrices only elementwise
+	sort.SliceStable(fs, func(i, j int) bool {
+		if isLiteralFactor(fs[i]) != isLiteralFactor(fs[j]) {
+			return isLiteralFactor(fs[i])
+		}
+		return op == ".*" && fs[i].String() < fs[j].String()
+	})
+
+	if len(fs) == 0 {
+		return literal(coef)
+	}
+	result := fs[0]
+	for _, f := range fs[1:] {
+		mul := op
+		if isLiteralFactor(result) {
+			mul = "*"
+		}
+		result = &Binary{Op: mul, Left: result, Right: f}
+	}
+	return scaled(coef, result)
+}
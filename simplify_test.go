@@ -0,0 +1,153 @@
+package mast_test
+
+import (
+	"fmt"
+	. "github.com/fatlotus/mast"
+	"math"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		Source string
+		Rep    string
+	}{
+		{"a''", "a"},
+		{"a.'.'", "a"},
+		{"++b", "b"},
+		{"-(-x)", "x"},
+		{"x * 1", "x"},
+		{"0 + y", "y"},
+		{"x * 0 + y", "(y + (0 * x))"},
+		{"0 * 2 + y", "y"},
+		{"A .* 0", "(0 * A)"},
+		{"x / 1 + 1 \\ y", "(x + y)"},
+		{"x .^ 1", "x"},
+		{"x^1", "(x ^ 1)"},
+		{"0.5^1", "0.5"},
+		{"2^10 - 3 * 4", "1012"},
+		{"2 * 3 * x", "(6 * x)"},
+		{"2*x + y - x", "(x + y)"},
+		{"x - x", "(0 * x)"},
+		{"2*A - A * 2", "(0 * A)"},
+		{"-x + y", "(y - x)"},
+		{"-(a - b)", "(b - a)"},
+		{"-a * -b", "(a * b)"},
+		{"A * 2 * B", "(2 * (A * B))"},
+		{"B .* A .* 2", "(2 * (A .* B))"},
+		{"a > b", "(b < a)"},
+		{"b == a && c", "((a == b) && c)"},
+		{"1 ? x : 2", "x"},
+		{"0 ? x : x", "x"},
+		{"1 ? x : y", "(1 ? x : y)"},
+		{"c ? x + 0 : x", "(c ? x : x)"},
+		{"sin(0 + x')", "(sin (' x))"},
+		{"0.1 + 0.2", "(0.1 + 0.2)"},
+	}
+
+	for _, test := range tests {
+		e, err := PEMDAS.ParseExpr(test.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := Simplify(e)
+		if s.String() != test.Rep {
+			t.Errorf("simplifying %s\ngot       %s\nexpecting %s", test.Source, s, test.Rep)
+		}
+		if again := Simplify(s); !Equal(again, s) {
+			t.Errorf("simplifying %s again gave %s, expecting %s", test.Source, again, s)
+		}
+		if e.String() != mustParse(t, test.Source).String() {
+			t.Errorf("simplifying %s changed the original tree", test.Source)
+		}
+	}
+}
+
+func mustParse(t *testing.T, source string) Expr {
+	e, err := PEMDAS.ParseExpr(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestSimplifyEquivalent(t *testing.T) {
+	pairs := []struct {
+		A, B  string
+		Equal bool
+	}{
+		{"a + b + c", "c + (b + a)", true},
+		{"x .* y - y .* x", "0 * (y .* x)", true},
+		{"x - x", "0", false},
+		{"2*(x + y) - x", "x + 2*y", false},
+		{"3*x - (x - y)", "y + 2*x", true},
+		{"x > y || z", "z || y < x", true},
+		{"A * B", "B * A", false},
+		{"0x10 * a", "a * 16", true},
+	}
+
+	for _, pair := range pairs {
+		a, b := Simplify(mustParse(t, pair.A)), Simplify(mustParse(t, pair.B))
+		if Equal(a, b) != pair.Equal {
+			t.Errorf("comparing %s and %s: got %s and %s, expecting Equal to be %v",
+				pair.A, pair.B, a, b, pair.Equal)
+		}
+	}
+}
+
+func TestSimplifyPreservesValue(t *testing.T) {
+	sources := []string{
+		"3*x - (x - y) + 2^3",
+		"-(x - y) * -(2 * y)",
+		"x .* y .* 2 - y .* x",
+		"(x > y ? x : y) * 1 + 0",
+		"sin(x) + -sin(x) + x''",
+		"x / 1 - 4 * x * 0.5",
+	}
+	values := map[string]float64{"x": 0.7, "y": 1.3}
+
+	for _, source := range sources {
+		e := mustParse(t, source)
+		if got, want := evalAt(t, Simplify(e), values), evalAt(t, e, values); math.Abs(got-want) > 1e-12 {
+			t.Errorf("simplifying %s gave %s, evaluating to %v rather than %v",
+				source, Simplify(e), got, want)
+		}
+	}
+}
+
+func TestSimplifyPreservesSize(t *testing.T) {
+	sources := []string{
+		"A - A",
+		"A .* 0 + b",
+		"2*A - A*2 - b",
+		"b - b + A",
+		"1 ? b : A",
+		"A > 1 ? b : b",
+	}
+	A := [][]float64{{1, math.NaN()}, {math.Inf(1), 2}}
+	b := 3.0
+
+	at := func(e Expr) string {
+		prog, err := NewEnv().CompileEquations(&Equation{Left: &Var{Name: "y"}, Right: e})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var y [][]float64
+		values := map[string]interface{}{"y": &y, "A": &A, "b": &b}
+		args := map[string]interface{}{}
+		for _, name := range prog.Vars() {
+			args[name] = values[name]
+		}
+		if err := prog.RunMap(args); err != nil {
+			t.Fatalf("%s, while evaluating %s", err, e)
+		}
+		return fmt.Sprint(y)
+	}
+
+	for _, source := range sources {
+		e := mustParse(t, source)
+		if got, want := at(Simplify(e)), at(e); got != want {
+			t.Errorf("simplifying %s gave %s\ngot       %s\nexpecting %s", source, Simplify(e), got, want)
+		}
+	}
+}
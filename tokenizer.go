@@ -1,52 +1,203 @@
 package mast
 
 import (
+	"fmt"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
+// A Pos is a location in the source code.
+type Pos struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number (in runes), starting at 1
+}
+
+// Represent this Pos as "line:column".
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// A Span is the range of source code that an Expr was parsed from. The End
+// position is just past the last character.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// A token is a single word or symbol of source code, along with its location.
+type token struct {
+	text string
+	span Span
+}
+
+// Returns whether r separates tokens without ending a line. A '\r' counts,
+// so that the "\r\n" of Windows line endings ends a line as "\n" does.
 func isWsp(r rune) bool {
-	return r == ' ' || r == '\t' || r == '\v'
+	return r == ' ' || r == '\t' || r == '\v' || r == '\r'
+}
+
+// A scanner walks through source code one rune at a time, keeping track of
+// the current position.
+type scanner struct {
+	src string
+	pos Pos
+}
+
+const eof = -1
+
+func (s *scanner) peek() rune {
+	if s.pos.Offset >= len(s.src) {
+		return eof
+	}
+	r, _ := utf8.DecodeRuneInString(s.src[s.pos.Offset:])
+	return r
+}
+
+func (s *scanner) next() {
+	r, size := utf8.DecodeRuneInString(s.src[s.pos.Offset:])
+	s.pos.Offset += size
+	if r == '\n' {
+		s.pos.Line++
+		s.pos.Column = 1
+	} else {
+		s.pos.Column++
+	}
+}
+
+// Returns the byte n bytes past the current position, or zero past the end.
+func (s *scanner) ahead(n int) byte {
+	if s.pos.Offset+n >= len(s.src) {
+		return 0
+	}
+	return s.src[s.pos.Offset+n]
+}
+
+func (s *scanner) skip(pred func(rune) bool) {
+	for c := s.peek(); c != eof && pred(c); c = s.peek() {
+		s.next()
+	}
 }
 
-type runePred func(rune) bool
+func isDecimal(r rune) bool {
+	return '0' <= r && r <= '9'
+}
 
-var tokenPreds = []runePred{
-	unicode.IsUpper,
-	unicode.IsLetter,
-	unicode.IsDigit,
-	isWsp,
+func isHex(r rune) bool {
+	return isDecimal(r) || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
 }
 
-func (p Parser) tokenize(code string) ([]string, error) {
-	st := runePred(nil)
-	matches := []string{}
-	buf := []rune{}
+// Scans a numeric literal, such as 42, 3.14, .5, 1e-9, 2.5E+3 or 0x1F. The
+// fraction and exponent are only consumed if digits follow, so that "2e" is
+// the number 2 followed by the variable e.
+func (s *scanner) number() {
+	if s.ahead(0) == '0' && (s.ahead(1) == 'x' || s.ahead(1) == 'X') &&
+		isHex(rune(s.ahead(2))) {
+		s.next()
+		s.next()
+		s.skip(isHex)
+		return
+	}
 
-	for _, c := range code {
-		if st != nil && st(c) {
-			buf = append(buf, c)
-		} else {
-			if len(buf) > 0 && !isWsp(buf[0]) {
-				matches = append(matches, string(buf))
+	s.skip(isDecimal)
+	if s.ahead(0) == '.' && isDecimal(rune(s.ahead(1))) {
+		s.next()
+		s.skip(isDecimal)
+	}
+	if c := s.ahead(0); c == 'e' || c == 'E' {
+		n := 1
+		if c := s.ahead(1); c == '+' || c == '-' {
+			n = 2
+		}
+		if isDecimal(rune(s.ahead(n))) {
+			for i := 0; i < n; i++ {
+				s.next()
 			}
+			s.skip(isDecimal)
+		}
+	}
+}
+
+// Scans the longest of the given glyphs that appears next in the source,
+// or a single rune if none do.
+func (s *scanner) glyph(glyphs []string) {
+	rest := s.src[s.pos.Offset:]
+	best := ""
+	for _, glyph := range glyphs {
+		if len(glyph) > len(best) && strings.HasPrefix(rest, glyph) {
+			best = glyph
+		}
+	}
+
+	if best == "" {
+		s.next()
+		return
+	}
+	for end := s.pos.Offset + len(best); s.pos.Offset < end; {
+		s.next()
+	}
+}
+
+// Returns every operator and grouping symbol used in this language, so that
+// multi-character operators like "<=" or ".*" can be recognized.
+func (p Parser) glyphs() []string {
+	glyphs := []string{"="}
+	if p.patterns {
+		glyphs = append(glyphs, "->")
+	}
+	for _, op := range p.Operators {
+		glyphs = append(glyphs, op.Glyphs...)
+	}
+	for _, group := range append(p.Parens, p.Brackets...) {
+		glyphs = append(glyphs, group.Left, group.Right)
+	}
+	return glyphs
+}
 
-			st = nil
-			for i, pred := range tokenPreds {
-				if pred(c) {
-					if i == 0 { // upper case letter
-						st = nil
-					} else {
-						st = pred
-					}
-					break
+func (p Parser) tokenize(code string) ([]token, error) {
+	s := &scanner{src: code, pos: Pos{0, 1, 1}}
+	tokens := []token{}
+	glyphs := p.glyphs()
+
+	for {
+		s.skip(isWsp)
+		start := s.pos
+
+		c := s.peek()
+		if c == eof {
+			break
+		}
+
+		switch {
+		case unicode.IsUpper(c): // upper case letters stand alone
+			s.next()
+		case unicode.IsLetter(c):
+			s.skip(unicode.IsLetter)
+		case isDecimal(c) || c == '.' && isDecimal(rune(s.ahead(1))):
+			s.number()
+		case unicode.IsDigit(c):
+			s.skip(unicode.IsDigit)
+		default:
+			s.glyph(glyphs)
+
+			// in patterns, a "?" just before a name makes a metavariable
+			if p.patterns && code[start.Offset:s.pos.Offset] == "?" {
+				if c := s.peek(); unicode.IsUpper(c) {
+					s.next()
+				} else if unicode.IsLetter(c) {
+					s.skip(unicode.IsLetter)
 				}
 			}
-			buf = buf[:0]
-			buf = append(buf, c)
 		}
+
+		tokens = append(tokens, token{
+			text: code[start.Offset:s.pos.Offset],
+			span: Span{start, s.pos},
+		})
 	}
 
-	matches = append(matches, string(buf))
-	matches = append(matches, "") // eof marker
-	return matches, nil
+	// eof marker
+	tokens = append(tokens, token{span: Span{s.pos, s.pos}})
+	return tokens, nil
 }
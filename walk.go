@@ -0,0 +1,87 @@
+package mast
+
+// A Visitor is called for each Expr found by WalkVisitor. If Visit returns
+// a non-nil Visitor w, the children of e are then visited with w, followed
+// by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(e Expr) (w Visitor)
+}
+
+// Returns the children of e, in the order they appear in the source: the
+// Operator and Operand of an Apply, the Elem of a Unary, the Left and Right
+// of a Binary or Equation, and the Test, Then and Else of a Cond. Var, Num
+// and any other Expr have none.
+func children(e Expr) []Expr {
+	switch e := e.(type) {
+	case *Apply:
+		return []Expr{e.Operator, e.Operand}
+	case *Unary:
+		return []Expr{e.Elem}
+	case *Binary:
+		return []Expr{e.Left, e.Right}
+	case *Cond:
+		return []Expr{e.Test, e.Then, e.Else}
+	case *Equation:
+		return []Expr{e.Left, e.Right}
+	}
+	return nil
+}
+
+// Returns a copy of e with the given children, in the order of children(e).
+// Any Expr of an unknown type is returned as it is.
+func withChildren(e Expr, c []Expr) Expr {
+	switch e := e.(type) {
+	case *Var:
+		v := *e
+		return &v
+	case *Num:
+		n := *e
+		return &n
+	case *Apply:
+		return &Apply{c[0], c[1], e.Span}
+	case *Unary:
+		return &Unary{e.Op, c[0], e.Span}
+	case *Binary:
+		return &Binary{e.Op, c[0], c[1], e.Span}
+	case *Cond:
+		return &Cond{c[0], c[1], c[2], e.Span}
+	case *Equation:
+		return &Equation{c[0], c[1], e.Span}
+	}
+	return e
+}
+
+// Calls f for e and then, if f returns true, for each of its children in
+// turn, so that the whole tree is visited depth-first in source order. Walk
+// covers every type of Expr, including Equation and Cond, so a caller need
+// only handle the types it is interested in.
+func Walk(e Expr, f func(Expr) bool) {
+	if f(e) {
+		for _, child := range children(e) {
+			Walk(child, f)
+		}
+	}
+}
+
+// Visits e with v, as described by Visitor.
+func WalkVisitor(v Visitor, e Expr) {
+	if v = v.Visit(e); v == nil {
+		return
+	}
+	for _, child := range children(e) {
+		WalkVisitor(v, child)
+	}
+	v.Visit(nil)
+}
+
+// Returns a copy of e rewritten bottom-up: each node is copied with its
+// children already transformed, and then replaced with the result of
+// calling f on the copy. The original tree is left unchanged, and f may
+// modify the copy it is given.
+func Transform(e Expr, f func(Expr) Expr) Expr {
+	c := []Expr{}
+	for _, child := range children(e) {
+		c = append(c, Transform(child, f))
+	}
+	return f(withChildren(e, c))
+}
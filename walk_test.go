@@ -0,0 +1,110 @@
+package mast_test
+
+import (
+	"fmt"
+	. "github.com/fatlotus/mast"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	tree, err := PEMDAS.Parse("y = sin(x) * -b + (c ? d' : 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	Walk(tree, func(e Expr) bool {
+		if v, ok := e.(*Var); ok {
+			names = append(names, v.Name)
+		}
+		return true
+	})
+	if got := strings.Join(names, " "); got != "y sin x b c d" {
+		t.Errorf("got variables %s, expecting y sin x b c d", got)
+	}
+
+	// skip the right-hand side of the multiplication
+	names = []string{}
+	Walk(tree, func(e Expr) bool {
+		if v, ok := e.(*Var); ok {
+			names = append(names, v.Name)
+		}
+		b, ok := e.(*Binary)
+		return !ok || b.Op != "*"
+	})
+	if got := strings.Join(names, " "); got != "y c d" {
+		t.Errorf("got variables %s, expecting y c d", got)
+	}
+}
+
+// Records the depth of every node.
+type depths struct {
+	depth  int
+	result *[]int
+}
+
+func (d depths) Visit(e Expr) Visitor {
+	if e == nil {
+		return nil
+	}
+	*d.result = append(*d.result, d.depth)
+	return depths{d.depth + 1, d.result}
+}
+
+// Counts the calls of Visit(nil), which follow the children of each node.
+type ends struct {
+	count *int
+}
+
+func (v ends) Visit(e Expr) Visitor {
+	if e == nil {
+		*v.count++
+	}
+	return v
+}
+
+func TestWalkVisitor(t *testing.T) {
+	tree, err := PEMDAS.Parse("y = a + f(b)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := []int{}
+	WalkVisitor(depths{0, &result}, tree)
+	if got, want := fmt.Sprint(result), "[0 1 1 2 2 3 3]"; got != want {
+		t.Errorf("got depths %s, expecting %s", got, want)
+	}
+
+	count := 0
+	WalkVisitor(ends{&count}, tree)
+	if count != 7 {
+		t.Errorf("got %d calls of Visit(nil), expecting 7", count)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tree, err := PEMDAS.Parse("y = a * (b + a) > 0 ? a : -a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := []string{}
+	renamed := Transform(tree, func(e Expr) Expr {
+		order = append(order, e.String())
+		if v, ok := e.(*Var); ok && v.Name == "a" {
+			return &Var{Name: "z"}
+		}
+		return e
+	})
+
+	if got, want := renamed.String(), "y = (((z * (b + z)) > 0) ? z : (- z))"; got != want {
+		t.Errorf("got %s, expecting %s", got, want)
+	}
+	if got, want := tree.String(), "y = (((a * (b + a)) > 0) ? a : (- a))"; got != want {
+		t.Errorf("transforming changed the original to %s", got)
+	}
+	if order[0] != "y" || order[len(order)-1] != renamed.String() {
+		t.Errorf("expected children to be transformed before their parents, got %v", order)
+	}
+}